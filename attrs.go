@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AttrSignature describes a package-level constant or variable exported as an
+// attribute of the Python module.
+type AttrSignature struct {
+	GoName string
+	PyName string
+	GoDoc  string
+	GoType *GoType
+
+	// The variable is read each time the attribute is accessed, through the
+	// __getattr__ function of the module, instead of once at import
+	Live bool
+
+	// Explicitly exported with a go:pyexport comment
+	Explicit bool
+
+	CGetterName string
+}
+
+// ProcessValues returns the module attributes of the constants or variables
+// declared by v. Under --export-all all the exported declarations are exported,
+// otherwise only the ones with a go:pyexport directive. The "live" option
+// exports variables as read-only attributes reflecting their current value.
+func ProcessValues(v *doc.Value, pkg *GoPackage) []*AttrSignature {
+	var res []*AttrSignature
+	for _, spec := range v.Decl.Specs {
+		vspec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		specDoc := v.Doc
+		if vspec.Doc != nil {
+			specDoc = vspec.Doc.Text()
+		}
+		attrDoc, isExport, options := ProcessDoc(specDoc)
+		if !args.ExportAll && !isExport {
+			continue
+		}
+
+		for _, name := range vspec.Names {
+			if !name.IsExported() {
+				continue
+			}
+			obj := pkg.Types.Scope().Lookup(name.Name)
+			if obj == nil {
+				log.Trace().Msgf("Skip %s", name.Name)
+				continue
+			}
+			goType, err := asGoType(types.Default(obj.Type()), nil, pkg)
+			if err == nil && goType.T == Error {
+				// Sentinel errors are exported as exceptions
+				continue
+			}
+			if err == nil && !goType.IsSettable() && goType.T != Func {
+				err = fmt.Errorf("Type '%s' not supported!", pkg.TypeString(obj.Type()))
+			}
+			if err != nil {
+				if isExport {
+					log.Fatal().
+						Caller().
+						Str("name", name.Name).
+						Err(err).
+						Msg("Could not export module attribute")
+				}
+				log.Trace().Err(err).Msgf("Skip %s", name.Name)
+				continue
+			}
+
+			res = append(res, &AttrSignature{
+				GoName:      name.Name,
+				PyName:      name.Name,
+				GoDoc:       strings.TrimSpace(attrDoc),
+				GoType:      goType,
+				Live:        v.Decl.Tok == token.VAR && options.Has("live"),
+				Explicit:    isExport,
+				CGetterName: "pyattr_" + name.Name,
+			})
+		}
+	}
+	return res
+}
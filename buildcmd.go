@@ -24,6 +24,15 @@ func (x *BuildCommand) Execute(rargs []string) error {
 		return err
 	}
 
+	soFilename := fmt.Sprintf("%s.so", args.PyModuleName)
+	if args.OutputDir != "" {
+		soFilename = path.Join(args.OutputDir, soFilename)
+	}
+	return x.BuildLibrary(args, ctx, soFilename)
+}
+
+// BuildLibrary compiles the generated code into the shared library soFilename.
+func (x *BuildCommand) BuildLibrary(args Args, ctx *PyExportContext, soFilename string) error {
 	goargs := []string{"build", "-buildmode=c-shared"}
 	tags := args.GoTags
 	if x.BuildTags != "" {
@@ -32,11 +41,6 @@ func (x *BuildCommand) Execute(rargs []string) error {
 	if len(tags) > 0 {
 		goargs = append(goargs, fmt.Sprintf("-tags=%s", strings.Join(tags, ",")))
 	}
-
-	soFilename := fmt.Sprintf("%s.so", args.PyModuleName)
-	if args.OutputDir != "" {
-		soFilename = path.Join(args.OutputDir, soFilename)
-	}
 	goargs = append(goargs, "-o", soFilename)
 
 	gocmd := exec.Command("go", goargs...)
@@ -63,7 +67,7 @@ func (x *BuildCommand) Execute(rargs []string) error {
 	}
 
 	log.Debug().Msgf("%v", gocmd)
-	err = gocmd.Run()
+	err := gocmd.Run()
 	if err != nil {
 		return err
 	}
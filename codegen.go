@@ -9,10 +9,12 @@ import (
 	"go/ast"
 	"go/doc"
 	"go/format"
-	"go/parser"
-	"go/token"
-	"io/ioutil"
+	"go/types"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -31,6 +33,15 @@ type FunctionSignature struct {
 	CRecv            string
 	CGoRecv          string
 	ReturnsAlsoError bool
+	ReleaseGIL       bool
+	Async            bool // Returns an asyncio future resolved from a goroutine
+	TakesContext     bool // The leading context.Context argument is supplied by the wrapper
+	Options          DirectiveOptions
+
+	// The method raises an exception once the receiver has been closed
+	CheckClosed bool
+	// The method closes the receiver
+	Closes bool
 
 	initDone                     bool
 	CFunctionName                string
@@ -42,6 +53,8 @@ type FunctionSignature struct {
 	ArgsCToGo                    []string
 	ArgsGoNames                  []string
 	ArgsCPyObject                []string
+	ArgsGoConvert                []string
+	ArgsGoCall                   []string
 }
 
 func (fs *FunctionSignature) init() {
@@ -74,6 +87,7 @@ func (fs *FunctionSignature) init() {
 	fs.ArgsGoC = make([]string, nargs)
 	fs.ArgsCToGo = make([]string, nargs)
 	fs.ArgsGoNames = make([]string, nargs)
+	fs.ArgsGoCall = make([]string, nargs)
 
 	i := 0
 	for _, arg := range fs.Args {
@@ -81,8 +95,21 @@ func (fs *FunctionSignature) init() {
 		fs.ArgsPythonNamesWithTypeHints[i] = fmt.Sprintf("%s: %s", arg.PythonName(), arg.PythonTypeHint())
 		fs.ArgsCPtrSignature[i] = fmt.Sprintf("%s%s", arg.CPtrType(), arg.PythonName())
 		fs.ArgsGoC[i] = fmt.Sprintf("var %s %s", arg.GoName, arg.GoCType())
-		fs.ArgsCToGo[i] = arg.CToGoFunction(arg.GoName)
+		switch arg.T {
+		case Map, Slice, NumpyArray, Pointer, Func, Enum, Struct:
+			// Name the argument in the TypeError raised if the conversion fails
+			fs.ArgsCToGo[i] = fmt.Sprintf("convertArg(\"%s\", func() %s { return %s })", arg.PythonName(), arg.GoTypeName(), arg.CToGoFunction(arg.GoName))
+		default:
+			fs.ArgsCToGo[i] = arg.CToGoFunction(arg.GoName)
+		}
 		fs.ArgsGoNames[i] = arg.GoName
+		if fs.ReleaseGIL || fs.Async || fs.TakesContext {
+			// Arguments are converted while holding the GIL, before the Go call
+			fs.ArgsGoConvert = append(fs.ArgsGoConvert, fmt.Sprintf("_%s := %s", arg.GoName, fs.ArgsCToGo[i]))
+			fs.ArgsGoCall[i] = "_" + arg.GoName
+		} else {
+			fs.ArgsGoCall[i] = fs.ArgsCToGo[i]
+		}
 		switch arg.T {
 		case CPyObjectPointer, Map, Slice:
 			fs.ArgsCPyObject = append(fs.ArgsCPyObject, arg.GoName)
@@ -90,33 +117,53 @@ func (fs *FunctionSignature) init() {
 		i++
 	}
 
+	if fs.TakesContext {
+		fs.ArgsGoCall = append([]string{"_ctx"}, fs.ArgsGoCall...)
+		// The timeout of the context is an optional keyword-only argument
+		fs.ArgsPythonNames = append(fs.ArgsPythonNames, "timeout")
+		fs.ArgsPythonNamesWithTypeHints = append(fs.ArgsPythonNamesWithTypeHints, "*", "timeout: float | None = None")
+		fs.ArgsCPtrSignature = append(fs.ArgsCPtrSignature, "PyObject **timeout")
+		fs.ArgsGoC = append(fs.ArgsGoC, "var timeout *C.PyObject")
+		fs.ArgsGoNames = append(fs.ArgsGoNames, "timeout")
+	}
+
 	fs.initDone = true
 }
 
 func (fs *FunctionSignature) HasArgs() bool {
-	return len(fs.Args) != 0
+	return len(fs.Args) != 0 || fs.TakesContext
 }
 
 func (fs *FunctionSignature) HasRecv() bool {
 	return fs.GoRecv != ""
 }
 
+func (fs *FunctionSignature) RecvTypeName() string {
+	return strings.TrimPrefix(fs.GoRecv, "*")
+}
+
 func (fs *FunctionSignature) PyArgFormat() string {
 	res := ""
 	for _, arg := range fs.Args {
 		res += arg.PyArgFormat()
 	}
+	if fs.TakesContext {
+		res += "|$O"
+	}
 	return res
 }
 
-func (fs *FunctionSignature) PyModuleDef() string {
-	fs.init()
-	var pyFunctionName string
+func (fs *FunctionSignature) PyFunctionName() string {
 	if args.UseSnakeCase {
-		pyFunctionName = ToSnakeCase(fs.GoFuncName)
+		return ToSnakeCase(fs.GoFuncName)
 	} else {
-		pyFunctionName = fs.GoFuncName
+		return fs.GoFuncName
 	}
+}
+
+func (fs *FunctionSignature) PyModuleDef() string {
+	fs.init()
+	pyFunctionName := fs.PyFunctionName()
 
 	doc, err := fs.PyModuleDefDoc(pyFunctionName)
 	if err != nil {
@@ -150,6 +197,26 @@ func CCodeString(v string) (string, error) {
 	return fmt.Sprintf(`"%s"`, v), nil
 }
 
+// PyDocString returns the docstring literal of doc for the .pyi stub file,
+// indented with indent.
+func PyDocString(doc string, indent string) string {
+	doc = strings.ReplaceAll(doc, `\`, `\\`)
+	doc = strings.ReplaceAll(doc, `"""`, `\"\"\"`)
+	if strings.HasSuffix(doc, `"`) {
+		doc += " "
+	}
+	lines := strings.Split(doc, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	if len(lines) == 1 {
+		return fmt.Sprintf(`%s"""%s"""`, indent, lines[0])
+	}
+	return fmt.Sprintf("%s\"\"\"%s\n%s\"\"\"", indent, strings.Join(lines, "\n"), indent)
+}
+
 func (fs *FunctionSignature) PyModuleDefDoc(pyFunctionName string) (string, error) {
 	fs.init()
 
@@ -166,10 +233,42 @@ func (fs *FunctionSignature) PyModuleDefDoc(pyFunctionName string) (string, erro
 	}
 }
 
+// PyStubSignature returns the signature of the function for the .pyi stub file.
+func (fs *FunctionSignature) PyStubSignature() string {
+	fs.init()
+
+	pyArgs := fs.ArgsPythonNamesWithTypeHints
+	if fs.HasRecv() {
+		pyArgs = append([]string{"self"}, pyArgs...)
+	}
+
+	returnHint := "None"
+	if fs.GoReturnType.T != None && fs.GoReturnType.T != Error {
+		returnHint = fs.GoReturnType.PythonTypeHint()
+	}
+	if fs.Async {
+		returnHint = fmt.Sprintf("asyncio.Future[%s]", returnHint)
+	}
+	return fmt.Sprintf("%s(%s) -> %s", fs.PyFunctionName(), strings.Join(pyArgs, ", "), returnHint)
+}
+
 func (fs *FunctionSignature) GoPyReturn(result string) string {
+	if fs.ReleasesContext() {
+		return fs.GoReturnType.GoPyStreamReturn(result, "_cancel")
+	}
 	return fs.GoReturnType.GoPyReturn(result)
 }
 
+// ReleasesContext returns true if the context outlives the call of the Go
+// function, and is cancelled once the returned Python iterator is released.
+func (fs *FunctionSignature) ReleasesContext() bool {
+	switch fs.GoReturnType.T {
+	case Seq, Seq2, Chan:
+		return fs.TakesContext && !fs.Async
+	}
+	return false
+}
+
 type FunctionArgument struct {
 	*GoType
 	GoName string
@@ -183,17 +282,6 @@ func (fa *FunctionArgument) PythonName() string {
 	}
 }
 
-func IsErrorType(field *ast.Field) bool {
-	ident, ok := field.Type.(*ast.Ident)
-	if !ok {
-		return false
-	}
-	if ident.Name == "error" {
-		return true
-	}
-	return false
-}
-
 func SafeWriteTemplate(tmpl *template.Template, templateName string, data any, fname string, formatter func([]byte) ([]byte, error)) error {
 	var err error
 	if formatter == nil {
@@ -233,18 +321,63 @@ type PyExportContext struct {
 	CHeaderFname string
 	Functions    []*FunctionSignature
 	Types        []*TypeSignature
+	Errors       []*ErrorSignature
+	Enums        []*EnumSignature
+	Attrs        []*AttrSignature // Module attributes
+	Structs      []*StructSignature
 	Imports      []string
-	WithNumpy    bool
+	// Named types of the imported packages, which may not be otherwise referred
+	// to by the generated code
+	ImportedTypes []string
+	WithNumpy     bool
+	WithIterator  bool
+	WithChannel   bool
+	WithAsync     bool
+	WithContext   bool
+	WithFunc      bool // Python callables converted to Go funcs
+	WithGoFunc    bool // Go funcs converted to Python callables
+	// Named types of the package, declared as type aliases in the Python stub
+	NamedTypes []*GoType
+}
+
+// LiveAttrs returns the module attributes read through the __getattr__
+// function of the module.
+func (ctx *PyExportContext) LiveAttrs() []*AttrSignature {
+	var res []*AttrSignature
+	for _, as := range ctx.Attrs {
+		if as.Live {
+			res = append(res, as)
+		}
+	}
+	return res
+}
+
+// WithHandles returns true if Go values are wrapped by Python objects holding a
+// cgo handle.
+func (ctx *PyExportContext) WithHandles() bool {
+	return len(ctx.Types) > 0 || ctx.WithIterator || ctx.WithChannel || ctx.WithGoFunc
+}
+
+// WithGoroutines returns true if Go functions run in goroutines while Python
+// waits for their result, or if Go funcs call Python from any goroutine.
+func (ctx *PyExportContext) WithGoroutines() bool {
+	return ctx.WithChannel || ctx.WithAsync || ctx.WithContext || ctx.WithFunc
+}
+
+// WithFuture returns true if asyncio futures are resolved from goroutines.
+func (ctx *PyExportContext) WithFuture() bool {
+	return ctx.WithChannel || ctx.WithAsync
 }
 
-func GeneratePyExportsCode(cCodeFname, cHeaderFname, goCodeFname, goPackageName string, goTags []string, fnSignatures []*FunctionSignature, tpSignatures []*TypeSignature, cModuleName string) (*PyExportContext, error) {
+func GeneratePyExportsCode(cCodeFname, cHeaderFname, goCodeFname, pyiFname string, pkg *GoPackage, goTags []string, fnSignatures []*FunctionSignature, tpSignatures []*TypeSignature, errSignatures []*ErrorSignature, enumSignatures []*EnumSignature, attrSignatures []*AttrSignature, cModuleName string) (*PyExportContext, error) {
 	if len(fnSignatures) == 0 {
 		return nil, errors.New("No function signature exported")
 	}
 
 	tmpl, err := template.New("goserpent").
 		Funcs(template.FuncMap{
-			"join": strings.Join,
+			"join":  strings.Join,
+			"pydoc": PyDocString,
 		}).
 		ParseFS(templateFiles, "templates/*")
 	if err != nil {
@@ -255,11 +388,113 @@ func GeneratePyExportsCode(cCodeFname, cHeaderFname, goCodeFname, goPackageName
 		fs.init()
 	}
 
+	exportedTypes := make(map[string]bool)
+	for _, ts := range tpSignatures {
+		exportedTypes[ts.GoTypeName] = true
+	}
+
 	requiresRuntimeCgo := false
+	withIterator, withChannel, withAsync, withContext := false, false, false, false
+	withFunc, withGoFunc := false, false
+	checkFuncs := func(g *GoType, fromPython bool) {
+		callables, goFuncs := g.UsesFunc(fromPython)
+		withFunc = withFunc || callables
+		withGoFunc = withGoFunc || goFuncs
+	}
+	// Named types declared as type aliases in the stub, and structs converted
+	// to dataclasses, including the ones used by the fields of the structs
+	namedTypes := make(map[string]*GoType)
+	structs := make(map[string]*GoType)
+	var addNamedTypes func(types ...*GoType)
+	addNamedTypes = func(types ...*GoType) {
+		for _, g := range types {
+			if g == nil {
+				continue
+			}
+			for _, n := range g.NamedTypes() {
+				namedTypes[n.Named] = n
+			}
+			for _, st := range g.Structs() {
+				if structs[st.GoRepr] == nil {
+					structs[st.GoRepr] = st
+					for _, f := range st.StructFields {
+						addNamedTypes(f.Type)
+					}
+				}
+			}
+		}
+	}
+	checkFunction := func(fs *FunctionSignature) {
+		withIterator = withIterator || fs.GoReturnType.Uses(Seq, Seq2)
+		withChannel = withChannel || fs.GoReturnType.Uses(Chan)
+		withAsync = withAsync || fs.Async
+		withContext = withContext || fs.TakesContext
+		for _, arg := range fs.Args {
+			checkFuncs(arg.GoType, true)
+			addNamedTypes(arg.GoType)
+		}
+		checkFuncs(fs.GoReturnType, false)
+		addNamedTypes(fs.GoReturnType)
+	}
 	for _, ts := range tpSignatures {
+		// Fields can only refer to types exported to Python
+		ts.Fields = slices.DeleteFunc(ts.Fields, func(f *FieldSignature) bool {
+			if f.GoType.RefersToTypes(exportedTypes) {
+				return false
+			}
+			if f.Explicit {
+				log.Fatal().
+					Caller().
+					Str("field", ts.GoTypeName+"."+f.GoName).
+					Msgf("Type '%s' is not exported", f.GoType.GoTypeName())
+			}
+			log.Trace().Msgf("Skip field %s.%s", ts.GoTypeName, f.GoName)
+			return true
+		})
+
+		ts.DropUnexported(ts.GoTypeName, exportedTypes)
+
 		ts.init()
+		withIterator = withIterator || ts.Iter != nil
+		for _, fs := range slices.Concat(ts.Methods, ts.Funcs) {
+			checkFunction(fs)
+		}
+		for _, f := range ts.Fields {
+			addNamedTypes(f.GoType)
+		}
+		for _, sm := range ts.SlotMethods() {
+			if sm.ValueType != nil {
+				checkFuncs(sm.ValueType, false)
+			}
+			addNamedTypes(sm.KeyType, sm.ValueType)
+		}
+		for _, op := range ts.Operators {
+			for _, om := range op.Methods() {
+				checkFuncs(om.ResultType, false)
+				addNamedTypes(om.OtherType, om.ResultType)
+			}
+		}
 		requiresRuntimeCgo = requiresRuntimeCgo || len(ts.Methods) > 0 || len(ts.Funcs) > 0
 	}
+	for _, fs := range fnSignatures {
+		checkFunction(fs)
+	}
+	// Module attributes can only refer to types exported to Python
+	attrSignatures = slices.DeleteFunc(attrSignatures, func(as *AttrSignature) bool {
+		if as.GoType.RefersToTypes(exportedTypes) {
+			checkFuncs(as.GoType, false)
+			addNamedTypes(as.GoType)
+			return false
+		}
+		if as.Explicit {
+			log.Fatal().
+				Caller().
+				Str("name", as.GoName).
+				Msgf("Type '%s' is not exported", as.GoType.GoTypeName())
+		}
+		log.Trace().Msgf("Skip %s", as.GoName)
+		return true
+	})
 
 	withNumpy := false
 L:
@@ -272,27 +507,67 @@ L:
 		}
 	}
 
-	imports := []string{"unsafe"}
+	imports := []string{"errors", "fmt", "runtime/debug", "unsafe"}
 	if requiresRuntimeCgo {
+		imports = append(imports, "math/bits")
+	}
+	withHandles := requiresRuntimeCgo || withIterator || withChannel || withGoFunc
+	if withHandles {
+		imports = append(imports, "sync/atomic")
+	}
+	if withHandles || (withAsync && withContext) {
 		imports = append(imports, "runtime/cgo")
 	}
-	if withNumpy {
-		imports = append(imports, "github.com/fabgeyer/goserpent/numpy")
+	if withIterator {
+		imports = append(imports, "iter")
+	}
+	if withChannel || withAsync || withContext || withFunc {
+		imports = append(imports, "runtime", "time")
+	}
+	if withFunc {
+		imports = append(imports, "sync")
+	}
+	if withContext {
+		imports = append(imports, "context")
+	}
+	// The packages of the named types referred to by the generated code
+	var importedTypes []string
+	for _, path := range slices.Sorted(maps.Keys(pkg.Imports())) {
+		if !slices.Contains(imports, path) {
+			imports = append(imports, path)
+			importedTypes = append(importedTypes, pkg.Imports()[path])
+		}
 	}
 
 	ctx := &PyExportContext{
-		GoTags:       strings.Join(goTags, " "),
-		PackageName:  goPackageName,
-		CModuleName:  cModuleName,
-		CHeaderFname: cHeaderFname,
-		Functions:    fnSignatures,
-		Types:        tpSignatures,
-		Imports:      imports,
-		WithNumpy:    withNumpy,
+		GoTags:        strings.Join(goTags, " "),
+		PackageName:   pkg.Name,
+		CModuleName:   cModuleName,
+		CHeaderFname:  cHeaderFname,
+		Functions:     fnSignatures,
+		Types:         tpSignatures,
+		Errors:        ResolveErrorSignatures(errSignatures),
+		Enums:         enumSignatures,
+		Attrs:         attrSignatures,
+		Imports:       imports,
+		ImportedTypes: importedTypes,
+		WithNumpy:     withNumpy,
+		WithIterator:  withIterator,
+		WithChannel:   withChannel,
+		WithAsync:     withAsync,
+		WithContext:   withContext,
+		WithFunc:      withFunc,
+		WithGoFunc:    withGoFunc,
+	}
+	for _, name := range slices.Sorted(maps.Keys(namedTypes)) {
+		ctx.NamedTypes = append(ctx.NamedTypes, namedTypes[name])
+	}
+	for _, name := range slices.Sorted(maps.Keys(structs)) {
+		ctx.Structs = append(ctx.Structs, NewStructSignature(structs[name], pkg))
 	}
 
 	cleanupFiles := func() {
-		for _, fname := range []string{goCodeFname, cCodeFname, cHeaderFname} {
+		for _, fname := range []string{goCodeFname, cCodeFname, cHeaderFname, pyiFname} {
 			os.Remove(fname)
 		}
 	}
@@ -325,18 +600,53 @@ L:
 		log.Fatal().Caller().Err(err).Msg("Failed to generate C header")
 		return nil, err
 	}
+
+	if pyiFname != "" {
+		log.Trace().Str("filename", pyiFname).Msg("Export Python stub")
+		err = SafeWriteTemplate(tmpl, "pyi", ctx, pyiFname, RemoveEmptyLines)
+		if err != nil {
+			cleanupFiles()
+			log.Fatal().Caller().Err(err).Msg("Failed to generate Python stub")
+			return nil, err
+		}
+	}
 	return ctx, nil
 }
 
-func ProcessDoc(doc string) (string, bool) {
+// DirectiveOptions holds the options following a go:pyexport directive,
+// e.g. "nogil" in "go:pyexport nogil".
+type DirectiveOptions []string
+
+func (d DirectiveOptions) Has(option string) bool {
+	for _, o := range d {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the value of a "key=value" option, or an empty string.
+func (d DirectiveOptions) Value(key string) string {
+	for _, o := range d {
+		if k, v, ok := strings.Cut(o, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func ProcessDoc(doc string) (string, bool, DirectiveOptions) {
 	var fnDoc string
 	var isExport bool
+	var options DirectiveOptions
 
 	scanner := bufio.NewScanner(strings.NewReader(strings.TrimSpace(doc)))
 	for scanner.Scan() {
 		txt := scanner.Text()
 		if strings.HasPrefix(txt, "go:pyexport") {
 			isExport = true
+			options = append(options, strings.Fields(strings.TrimPrefix(txt, "go:pyexport"))...)
 		} else {
 			if fnDoc == "" {
 				fnDoc = txt
@@ -345,11 +655,11 @@ func ProcessDoc(doc string) (string, bool) {
 			}
 		}
 	}
-	return fnDoc, isExport
+	return strings.TrimSpace(fnDoc), isExport, options
 }
 
-func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
-	fnDoc, isExport := ProcessDoc(fn.Doc)
+func ProcessFunc(fn *doc.Func, pkg *GoPackage) *FunctionSignature {
+	fnDoc, isExport, options := ProcessDoc(fn.Doc)
 
 	numReturnFields := fn.Decl.Type.Results.NumFields()
 	// Check if the function returns a *C.PyObject
@@ -360,21 +670,37 @@ func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
 		return nil
 	}
 
-	var args []FunctionArgument
-	for _, list := range fn.Decl.Type.Params.List {
-		goType, err := AsGoType(list.Type, sourceContent)
+	var fnArgs []FunctionArgument
+	var takesContext bool
+	for i, list := range fn.Decl.Type.Params.List {
+		goType, err := AsGoType(list.Type, pkg)
+		if err == nil && goType.T == Context && i == 0 && len(list.Names) <= 1 {
+			// The context is supplied by the wrapper
+			takesContext = true
+			continue
+		}
+		if err == nil && goType.Uses(Seq, Seq2, Chan) {
+			err = errors.New("Iterators and channels are only supported as return values")
+		}
+		if err == nil && goType.Uses(Context) {
+			err = errors.New("context.Context is only supported as the first argument")
+		}
+		if err == nil {
+			err = goType.CheckFunc(true)
+		}
 		if err != nil {
 			log.Fatal().
 				Caller().
 				Str("function", fn.Name).
-				Msgf("Argument type '%s' not supported!", GetSourceString(sourceContent, list.Type))
+				Err(err).
+				Msgf("Argument type '%s' not supported!", pkg.Source(list.Type))
 		}
 
 		for _, n := range list.Names {
 			if err != nil {
 				log.Fatal().Caller().Err(err).Send()
 			}
-			args = append(args, FunctionArgument{
+			fnArgs = append(fnArgs, FunctionArgument{
 				GoName: n.Name,
 				GoType: goType,
 			})
@@ -391,7 +717,13 @@ func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
 
 	} else if numReturnFields == 1 || numReturnFields == 2 {
 		var err error
-		goReturnType, err = AsGoType(fn.Decl.Type.Results.List[0].Type, sourceContent)
+		goReturnType, err = AsGoType(fn.Decl.Type.Results.List[0].Type, pkg)
+		if err == nil && goReturnType.T != Context && goReturnType.Uses(Context) {
+			err = errors.New("context.Context is only supported as the first argument")
+		}
+		if err == nil {
+			err = goReturnType.CheckFunc(false)
+		}
 		if err != nil {
 			log.Fatal().
 				Caller().
@@ -401,14 +733,14 @@ func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
 		}
 
 		if numReturnFields == 2 {
-			if IsErrorType(fn.Decl.Type.Results.List[1]) {
+			if pkg.IsPredeclared(fn.Decl.Type.Results.List[1].Type, "error") {
 				returnsAlsoError = true
 			} else {
 				log.Fatal().
 					Caller().
 					Str("function", fn.Name).
 					Str("type", fmt.Sprintf("%T", fn.Decl.Type.Results.List[1])).
-					Msgf("Return type '%s' not supported!", GetSourceString(sourceContent, fn.Decl.Type.Results.List[1]))
+					Msgf("Return type '%s' not supported!", pkg.Source(fn.Decl.Type.Results.List[1]))
 			}
 		}
 
@@ -418,6 +750,48 @@ func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
 			Msgf("Invalid return signature for function %s", fn.Name)
 	}
 
+	releaseGIL := options.Has("nogil") || args.ReleaseGIL
+	if releaseGIL {
+		if reason := RequiresGIL(fnArgs, goReturnType); reason != "" {
+			if options.Has("nogil") {
+				log.Fatal().
+					Caller().
+					Str("function", fn.Name).
+					Msgf("Cannot release the GIL: %s", reason)
+			}
+			log.Warn().
+				Str("function", fn.Name).
+				Msgf("Keeping the GIL: %s", reason)
+			releaseGIL = false
+		}
+	}
+
+	async := options.Has("async")
+	if async || takesContext {
+		if reason := RequiresGIL(fnArgs, goReturnType); reason != "" {
+			msg := "Cannot call asynchronously: %s"
+			if !async {
+				msg = "Cannot take a context.Context: %s"
+			}
+			log.Fatal().
+				Caller().
+				Str("function", fn.Name).
+				Msgf(msg, reason)
+		}
+		// The Go function is called from a goroutine, which does not hold the GIL
+		releaseGIL = false
+	}
+	if takesContext {
+		for _, arg := range fnArgs {
+			if arg.PythonName() == "timeout" {
+				log.Fatal().
+					Caller().
+					Str("function", fn.Name).
+					Msg("The 'timeout' argument conflicts with the timeout of the context")
+			}
+		}
+	}
+
 	var recv string
 	if fn.Recv != "" {
 		if !strings.HasPrefix(fn.Recv, "*") {
@@ -431,12 +805,32 @@ func ProcessFunc(fn *doc.Func, sourceContent []byte) *FunctionSignature {
 
 	return &FunctionSignature{
 		GoFuncName:       fn.Name,
-		Args:             args,
+		Args:             fnArgs,
 		GoReturnType:     goReturnType,
 		GoDoc:            strings.TrimSpace(fnDoc),
 		GoRecv:           recv,
 		ReturnsAlsoError: returnsAlsoError,
+		ReleaseGIL:       releaseGIL,
+		Async:            async,
+		TakesContext:     takesContext,
+		Options:          options,
+	}
+}
+
+// RequiresGIL returns a non-empty reason if the Go function cannot be called
+// without holding the GIL, i.e. if it directly manipulates Python objects.
+func RequiresGIL(fnArgs []FunctionArgument, returnType *GoType) string {
+	for _, arg := range fnArgs {
+		switch arg.T {
+		case CPyObjectPointer, NumpyArray:
+			return fmt.Sprintf("argument '%s' is a Python object", arg.GoName)
+		}
 	}
+	switch returnType.T {
+	case CPyObjectPointer, NumpyArray:
+		return "return value is a Python object"
+	}
+	return ""
 }
 
 type TypeSignature struct {
@@ -445,6 +839,23 @@ type TypeSignature struct {
 	GoDoc            string
 	Methods          []*FunctionSignature
 	Funcs            []*FunctionSignature
+	Fields           []*FieldSignature
+
+	// Function called when the type is instantiated from Python
+	Constructor *FunctionSignature
+
+	// The same Go pointer is always wrapped by the same Python object
+	Identity bool
+
+	TypeSlots
+}
+
+// PyStubInitSignature returns the signature of the __init__ method of the
+// type in the .pyi stub file.
+func (ts *TypeSignature) PyStubInitSignature() string {
+	ts.Constructor.init()
+	pyArgs := append([]string{"self"}, ts.Constructor.ArgsPythonNamesWithTypeHints...)
+	return fmt.Sprintf("__init__(%s) -> None", strings.Join(pyArgs, ", "))
 }
 
 func (ts *TypeSignature) init() {
@@ -456,13 +867,13 @@ func (ts *TypeSignature) init() {
 	}
 }
 
-func ProcessType(tp *doc.Type, sourceContent []byte) *TypeSignature {
+func ProcessType(tp *doc.Type, pkg *GoPackage) *TypeSignature {
 	var methods []*FunctionSignature
 	for _, fn := range tp.Methods {
 		if fn.Level != 0 {
 			continue
 		}
-		fs := ProcessFunc(fn, sourceContent)
+		fs := ProcessFunc(fn, pkg)
 		if fs != nil {
 			methods = append(methods, fs)
 		}
@@ -473,7 +884,7 @@ func ProcessType(tp *doc.Type, sourceContent []byte) *TypeSignature {
 		if fn.Level != 0 {
 			continue
 		}
-		fs := ProcessFunc(fn, sourceContent)
+		fs := ProcessFunc(fn, pkg)
 		if fs != nil {
 			funcs = append(funcs, fs)
 		}
@@ -483,7 +894,28 @@ func ProcessType(tp *doc.Type, sourceContent []byte) *TypeSignature {
 		return nil
 	}
 
-	tpDoc, _ := ProcessDoc(tp.Doc)
+	tpDoc, _, tpOptions := ProcessDoc(tp.Doc)
+	constructor := FindConstructor(tp.Name, funcs)
+
+	var fields []*FieldSignature
+	for _, spec := range tp.Decl.Specs {
+		if tspec, ok := spec.(*ast.TypeSpec); ok && tspec.Name.Name == tp.Name {
+			if st, ok := tspec.Type.(*ast.StructType); ok {
+				fields = ProcessFields(tp.Name, st, pkg)
+			}
+		}
+	}
+
+	slots := ProcessSlots(tp, pkg)
+	if slots.HasClose {
+		for _, m := range methods {
+			m.CheckClosed = true
+			m.Closes = m.GoFuncName == "Close"
+		}
+		for _, f := range fields {
+			f.CheckClosed = true
+		}
+	}
 
 	return &TypeSignature{
 		GoTypeName:       tp.Name,
@@ -491,74 +923,294 @@ func ProcessType(tp *doc.Type, sourceContent []byte) *TypeSignature {
 		GoDoc:            tpDoc,
 		Methods:          methods,
 		Funcs:            funcs,
+		Fields:           fields,
+		Constructor:      constructor,
+		Identity:         tpOptions.Has("identity"),
+		TypeSlots:        slots,
 	}
 }
 
-func GetSourceString(content []byte, node ast.Node) string {
-	return string(content[node.Pos()-1 : node.End()-1])
+// IsValueType returns true if the named type is converted by value through its
+// underlying type, instead of being exported as a Python type. Types with
+// methods or returned as pointers are exported as Python types.
+func IsValueType(tp *doc.Type, pkg *GoPackage) bool {
+	obj := pkg.Types.Scope().Lookup(tp.Name)
+	if obj == nil {
+		return false
+	}
+	named, ok := types.Unalias(obj.Type()).(*types.Named)
+	return ok && pkg.IsValueType(named)
 }
 
-func DoPyExports(args Args, fnames []string) (*PyExportContext, error) {
-	var fnPackage string
-	var fnSignatures []*FunctionSignature
-	var tpSignatures []*TypeSignature
-
-	for _, fname := range fnames {
-		log.Trace().Msgf("Process %s", fname)
+// FindConstructor returns the function used as the constructor of the type
+// from Python: the function with the go:pyexport constructor option, or else
+// the New<Type> function. The constructor has to return a pointer to the type,
+// and optionally an error.
+func FindConstructor(typeName string, funcs []*FunctionSignature) *FunctionSignature {
+	returnsType := func(fs *FunctionSignature) bool {
+		return fs.GoReturnType.T == Pointer && fs.GoReturnType.GoRepr == typeName
+	}
 
-		content, err := ioutil.ReadFile(fname)
-		if err != nil {
-			log.Fatal().Caller().Str("filename", fname).Err(err).Msg("")
+	var constructor *FunctionSignature
+	for _, fs := range funcs {
+		if !fs.Options.Has("constructor") {
+			continue
+		}
+		if !returnsType(fs) {
+			log.Fatal().
+				Caller().
+				Str("function", fs.GoFuncName).
+				Msgf("Constructor has to return *%s", typeName)
+		}
+		if constructor != nil {
+			log.Fatal().
+				Caller().
+				Str("type", typeName).
+				Msgf("Multiple constructors: %s and %s", constructor.GoFuncName, fs.GoFuncName)
 		}
+		constructor = fs
+	}
+	if constructor != nil {
+		return constructor
+	}
 
-		fset := token.NewFileSet()
-		f, err := parser.ParseFile(fset, fname, content, parser.ParseComments)
-		if err != nil {
-			log.Fatal().Caller().Str("filename", fname).Err(err).Msg("")
+	for _, fs := range funcs {
+		if fs.GoFuncName == "New"+typeName && returnsType(fs) {
+			return fs
 		}
+	}
+	return nil
+}
 
-		pkg, err := doc.NewFromFiles(fset, []*ast.File{f}, "", doc.PreserveAST)
-		if err != nil {
-			log.Fatal().Caller().Str("filename", fname).Err(err).Msg("")
+// FieldSignature describes a struct field exposed as a Python property.
+type FieldSignature struct {
+	GoName     string
+	PyName     string
+	GoTypeName string
+	GoType     *GoType
+	GoDoc      string
+	ReadOnly   bool
+
+	// The field raises an exception once the object has been closed
+	CheckClosed bool
+
+	// Explicitly exported with a pyexport struct tag or a go:pyexport comment
+	Explicit bool
+
+	CGetterName string
+	CSetterName string
+}
+
+func (f *FieldSignature) CDoc() string {
+	if f.GoDoc == "" {
+		return "NULL"
+	}
+	doc, err := CCodeString(f.GoDoc)
+	if err != nil {
+		log.Fatal().
+			Caller().
+			Str("field", f.GoName).
+			Err(err).
+			Msg("Could not generate documentation")
+	}
+	return doc
+}
+
+// ProcessFields returns the exported fields of a struct. If some fields have a
+// pyexport struct tag or a go:pyexport comment, only these fields are
+// exported. Fields with the `pyexport:"-"` tag are never exported.
+func ProcessFields(typeName string, st *ast.StructType, pkg *GoPackage) []*FieldSignature {
+	var fields []*FieldSignature
+	hasExplicit := false
+
+	for _, field := range st.Fields.List {
+		var tagName string
+		var tagOptions []string
+		var hasTag bool
+		if field.Tag != nil {
+			tag, _ := strconv.Unquote(field.Tag.Value)
+			if v, ok := reflect.StructTag(tag).Lookup("pyexport"); ok {
+				if v == "-" {
+					continue
+				}
+				hasTag = true
+				tagName, _, _ = strings.Cut(v, ",")
+				tagOptions = strings.Split(v, ",")[1:]
+			}
 		}
 
-		if fnPackage == "" {
-			fnPackage = pkg.Name
-			log.Trace().Msgf("Detected Go package '%s'", fnPackage)
-		} else if pkg.Name != fnPackage {
-			log.Trace().Msgf("Detected Go package '%s' != '%s'", pkg.Name, fnPackage)
-			log.Fatal().Caller().Msg("All files need to be in the same package!")
+		var fieldDoc string
+		if field.Doc != nil {
+			fieldDoc = field.Doc.Text()
+		} else if field.Comment != nil {
+			fieldDoc = field.Comment.Text()
+		}
+		fDoc, isExport, options := ProcessDoc(fieldDoc)
+		explicit := hasTag || isExport
+		readOnly := options.Has("readonly") || slices.Contains(tagOptions, "readonly")
+
+		goType, err := AsGoType(field.Type, pkg)
+		if err == nil {
+			switch goType.T {
+			case Byte, Error, CPyObjectPointer, NumpyArray, Chan, Context, Func:
+				err = fmt.Errorf("Type '%s' not supported!", pkg.Source(field.Type))
+			}
 		}
 
-		for _, fn := range pkg.Funcs {
-			if fn.Level != 0 {
+		for _, name := range field.Names {
+			if !name.IsExported() {
 				continue
 			}
-			fs := ProcessFunc(fn, content)
-			if fs == nil {
+			if err != nil {
+				if explicit {
+					log.Fatal().
+						Caller().
+						Str("field", typeName+"."+name.Name).
+						Err(err).
+						Send()
+				}
+				log.Trace().Err(err).Msgf("Skip field %s.%s", typeName, name.Name)
 				continue
 			}
 
-			log.Debug().
-				Str("filename", fname).
-				Msgf("Exporting %s", fn.Name)
-			fnSignatures = append(fnSignatures, fs)
+			fs := &FieldSignature{
+				GoName:      name.Name,
+				PyName:      tagName,
+				GoTypeName:  typeName,
+				GoType:      goType,
+				GoDoc:       strings.TrimSpace(fDoc),
+				ReadOnly:    readOnly || !goType.IsSettable(),
+				Explicit:    explicit,
+				CGetterName: fmt.Sprintf("pyexport_%s_get_%s", typeName, name.Name),
+				CSetterName: fmt.Sprintf("pyexport_%s_set_%s", typeName, name.Name),
+			}
+			if fs.PyName == "" || len(field.Names) > 1 {
+				if args.UseSnakeCase {
+					fs.PyName = ToSnakeCase(name.Name)
+				} else {
+					fs.PyName = name.Name
+				}
+			}
+			fields = append(fields, fs)
+			hasExplicit = hasExplicit || explicit
 		}
+	}
 
-		for _, tp := range pkg.Types {
-			ts := ProcessType(tp, content)
-			if ts == nil {
-				continue
-			}
+	if hasExplicit {
+		fields = slices.DeleteFunc(fields, func(f *FieldSignature) bool { return !f.Explicit })
+	}
+	return fields
+}
+
+func DoPyExports(args Args, patterns []string) (*PyExportContext, error) {
+	var fnSignatures []*FunctionSignature
+	var tpSignatures []*TypeSignature
+	var errSignatures []*ErrorSignature
+	var enumSignatures []*EnumSignature
+	var attrSignatures []*AttrSignature
+
+	pkg, err := LoadPackage(patterns, args.GoTags)
+	if err != nil {
+		log.Fatal().Caller().Strs("patterns", patterns).Err(err).Msg("")
+	}
+	docPkg, err := doc.NewFromFiles(pkg.Fset, pkg.Files, pkg.Path, doc.PreserveAST)
+	if err != nil {
+		log.Fatal().Caller().Str("package", pkg.Path).Err(err).Msg("")
+	}
+	filename := func(node ast.Node) string {
+		return pkg.Fset.Position(node.Pos()).Filename
+	}
 
-			for _, m := range ts.Methods {
+	exportFunc := func(fn *doc.Func) {
+		if fn.Level != 0 {
+			return
+		}
+		fs := ProcessFunc(fn, pkg)
+		if fs == nil {
+			return
+		}
+
+		log.Debug().
+			Str("filename", filename(fn.Decl)).
+			Msgf("Exporting %s", fn.Name)
+		fnSignatures = append(fnSignatures, fs)
+	}
+	for _, fn := range docPkg.Funcs {
+		exportFunc(fn)
+	}
+
+	exportValues := func(values []*doc.Value) {
+		for _, v := range values {
+			for _, as := range ProcessValues(v, pkg) {
 				log.Debug().
-					Str("filename", fname).
-					Msgf("Exporting %s.%s", tp.Name, m.GoFuncName)
+					Str("filename", filename(v.Decl)).
+					Msgf("Exporting %s", as.GoName)
+				attrSignatures = append(attrSignatures, as)
+			}
+		}
+	}
+	exportValues(docPkg.Consts)
+	exportValues(docPkg.Vars)
+
+	for _, v := range docPkg.Vars {
+		for _, es := range ProcessErrorVars(v) {
+			log.Debug().
+				Str("filename", filename(v.Decl)).
+				Msgf("Exporting error %s as %s", es.GoName, es.PyClassName)
+			errSignatures = append(errSignatures, es)
+		}
+	}
+
+	for _, tp := range docPkg.Types {
+		exportValues(tp.Vars)
+		if es := ProcessErrorType(tp); es != nil {
+			log.Debug().
+				Str("filename", filename(tp.Decl)).
+				Msgf("Exporting error type %s as %s", es.GoName, es.PyClassName)
+			errSignatures = append(errSignatures, es)
+			// The error is only raised as an exception, so its methods and
+			// the functions returning it are not exported
+			for _, fn := range slices.Concat(tp.Methods, tp.Funcs) {
+				if _, isExport, _ := ProcessDoc(fn.Doc); isExport && fn.Level == 0 {
+					log.Warn().
+						Str("filename", filename(fn.Decl)).
+						Msgf("%s of the error type %s is not exported", fn.Name, tp.Name)
+				}
+			}
+			continue
+		}
+		if es := ProcessEnumType(tp, pkg); es != nil {
+			log.Debug().
+				Str("filename", filename(tp.Decl)).
+				Msgf("Exporting enum %s", es.GoName)
+			enumSignatures = append(enumSignatures, es)
+			// The methods of the type, e.g. String, are not exported
+			for _, fn := range tp.Funcs {
+				exportFunc(fn)
+			}
+			continue
+		}
+		exportValues(tp.Consts)
+		if IsValueType(tp, pkg) {
+			// The functions returning the type are functions of the module
+			for _, fn := range tp.Funcs {
+				exportFunc(fn)
 			}
-			tpSignatures = append(tpSignatures, ts)
+			continue
+		}
+
+		ts := ProcessType(tp, pkg)
+		if ts == nil {
+			continue
+		}
+
+		for _, m := range ts.Methods {
+			log.Debug().
+				Str("filename", filename(tp.Decl)).
+				Msgf("Exporting %s.%s", tp.Name, m.GoFuncName)
 		}
+		tpSignatures = append(tpSignatures, ts)
 	}
 
-	return GeneratePyExportsCode(args.OutputCCode, args.OutputChdrCode, args.OutputGoCode, fnPackage, args.GoTags, fnSignatures, tpSignatures, args.PyModuleName)
+	return GeneratePyExportsCode(args.OutputCCode, args.OutputChdrCode, args.OutputGoCode, args.OutputPyiStub, pkg, args.GoTags, fnSignatures, tpSignatures, errSignatures, enumSignatures, attrSignatures, args.PyModuleName)
 }
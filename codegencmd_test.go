@@ -12,11 +12,12 @@ func TestCodegenCmd(t *testing.T) {
 		OutputCCode:    "pyexports.c",
 		OutputChdrCode: "pyexports.h",
 		OutputGoCode:   "pyexports.go",
+		OutputPyiStub:  "testmodule.pyi",
 		PyModuleName:   "testmodule",
 		GoTags:         []string{"python"},
 	}
 
-	DoPyExports(args, []string{"testfile.go"})
+	DoPyExports(args, []string{"testfile.go", "testfileloader.go"})
 
 	cmd := exec.Command("go", "build", "-buildmode=c-shared",
 		fmt.Sprintf("--tags=%s", args.GoTags[0]), "-o", "testmodule.so")
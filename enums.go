@@ -0,0 +1,142 @@
+package main
+
+import (
+	"go/constant"
+	"go/doc"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EnumSignature describes a Python enum class generated from a named integer or
+// string type and its constants (type Mode int with an iota const block).
+type EnumSignature struct {
+	GoName  string
+	GoDoc   string
+	GoType  *GoType
+	Members []*EnumMember
+
+	CVarName string
+}
+
+// EnumMember is a constant of the enum type.
+type EnumMember struct {
+	GoName  string
+	PyName  string
+	PyValue string // Value of the member in the .pyi stub file
+
+	// The member has the same value as a previous member, and is an alias of
+	// it in Python
+	Alias bool
+}
+
+// IsString returns true for the enums of string values, exported as StrEnum.
+func (es *EnumSignature) IsString() bool {
+	return es.GoType.EnumValueType().T == String
+}
+
+// PyStubBase returns the base class of the enum for the .pyi stub file.
+func (es *EnumSignature) PyStubBase() string {
+	if es.IsString() {
+		return "enum.StrEnum"
+	}
+	return "enum.IntEnum"
+}
+
+// ValueFormat returns the format of the values in the raised ValueError.
+func (es *EnumSignature) ValueFormat() string {
+	if es.IsString() {
+		return "%q"
+	}
+	return "%d"
+}
+
+func (es *EnumSignature) CDoc() string {
+	if es.GoDoc == "" {
+		return "NULL"
+	}
+	doc, err := CCodeString(es.GoDoc)
+	if err != nil {
+		log.Fatal().
+			Caller().
+			Str("enum", es.GoName).
+			Err(err).
+			Msg("Could not generate documentation")
+	}
+	return doc
+}
+
+// ProcessEnumType returns the enum signature if the type is an integer or
+// string type with the go:pyexport enum directive and exported constants. The
+// "names=upper" and "names=snake" options change the case of the member names.
+func ProcessEnumType(tp *doc.Type, pkg *GoPackage) *EnumSignature {
+	obj, ok := pkg.Types.Scope().Lookup(tp.Name).(*types.TypeName)
+	if !ok {
+		return nil
+	}
+	named, ok := obj.Type().(*types.Named)
+	if !ok {
+		return nil
+	}
+	consts := pkg.EnumConsts(named)
+	if len(consts) == 0 {
+		return nil
+	}
+	goType, err := asGoType(named, nil, pkg)
+	if err != nil || goType.T != Enum {
+		log.Trace().Err(err).Msgf("Skip enum %s", tp.Name)
+		return nil
+	}
+
+	tpDoc, _, options := ProcessDoc(tp.Doc)
+	names := options.Value("names")
+	switch names {
+	case "", "upper", "snake":
+	default:
+		log.Fatal().
+			Caller().
+			Str("enum", tp.Name).
+			Msgf("Unknown names option '%s', expected upper or snake", names)
+	}
+
+	es := &EnumSignature{
+		GoName:   tp.Name,
+		GoDoc:    strings.TrimSpace(tpDoc),
+		GoType:   goType,
+		CVarName: "pyenum_" + tp.Name,
+	}
+	values := make(map[string]bool)
+	for _, c := range consts {
+		value := c.Val().ExactString()
+		if c.Val().Kind() == constant.String {
+			value = strconv.Quote(constant.StringVal(c.Val()))
+		}
+		es.Members = append(es.Members, &EnumMember{
+			GoName:  c.Name(),
+			PyName:  PyEnumMemberName(tp.Name, c.Name(), names),
+			PyValue: value,
+			Alias:   values[value],
+		})
+		values[value] = true
+	}
+	return es
+}
+
+// PyEnumMemberName returns the name of the Python enum member for the Go
+// constant, without the name of the type as prefix, e.g. Read for ModeRead.
+func PyEnumMemberName(typeName, goName, names string) string {
+	name := goName
+	if rest, ok := strings.CutPrefix(name, typeName); ok && rest != "" && unicode.IsUpper(rune(rest[0])) {
+		name = rest
+	}
+	switch names {
+	case "upper":
+		return strings.ToUpper(ToSnakeCase(name))
+	case "snake":
+		return ToSnakeCase(name)
+	}
+	return name
+}
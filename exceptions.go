@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Python built-in exceptions which can be used as base class of the generated
+// exceptions with the "base=" option of the go:pyexport directive
+var pyBuiltinExceptions = map[string]bool{
+	"ArithmeticError":        true,
+	"AssertionError":         true,
+	"AttributeError":         true,
+	"BlockingIOError":        true,
+	"BrokenPipeError":        true,
+	"BufferError":            true,
+	"ChildProcessError":      true,
+	"ConnectionAbortedError": true,
+	"ConnectionError":        true,
+	"ConnectionRefusedError": true,
+	"ConnectionResetError":   true,
+	"EOFError":               true,
+	"FileExistsError":        true,
+	"FileNotFoundError":      true,
+	"IndexError":             true,
+	"InterruptedError":       true,
+	"IsADirectoryError":      true,
+	"KeyError":               true,
+	"LookupError":            true,
+	"MemoryError":            true,
+	"NotADirectoryError":     true,
+	"NotImplementedError":    true,
+	"OSError":                true,
+	"OverflowError":          true,
+	"PermissionError":        true,
+	"ProcessLookupError":     true,
+	"RecursionError":         true,
+	"ReferenceError":         true,
+	"RuntimeError":           true,
+	"TimeoutError":           true,
+	"TypeError":              true,
+	"UnicodeError":           true,
+	"ValueError":             true,
+	"ZeroDivisionError":      true,
+}
+
+// ErrorSignature describes a Python exception class generated either from a
+// sentinel error (var ErrNotFound = errors.New(...)) or from an error type.
+type ErrorSignature struct {
+	GoName      string
+	PyClassName string
+	GoDoc       string
+	PyBaseName  string
+
+	// Type used as errors.As target for error types, empty for sentinels
+	GoAsType string
+
+	CVarName string
+	CBases   string
+}
+
+func (es *ErrorSignature) IsSentinel() bool {
+	return es.GoAsType == ""
+}
+
+// ExportedBaseName returns the name of the base class if it is one of the
+// generated exceptions, or an empty string.
+func (es *ErrorSignature) ExportedBaseName() string {
+	if pyBuiltinExceptions[es.PyBaseName] {
+		return ""
+	}
+	return es.PyBaseName
+}
+
+// PyStubBases returns the base classes of the exception for the .pyi stub file.
+func (es *ErrorSignature) PyStubBases() string {
+	switch {
+	case es.PyBaseName == "":
+		return "GoError"
+	case pyBuiltinExceptions[es.PyBaseName]:
+		// The exception may shadow the built-in one, e.g. TimeoutError
+		return fmt.Sprintf("builtins.%s, GoError", es.PyBaseName)
+	default:
+		return es.PyBaseName
+	}
+}
+
+func (es *ErrorSignature) CDoc() string {
+	if es.GoDoc == "" {
+		return "NULL"
+	}
+	doc, err := CCodeString(es.GoDoc)
+	if err != nil {
+		log.Fatal().
+			Caller().
+			Str("error", es.GoName).
+			Err(err).
+			Msg("Could not generate documentation")
+	}
+	return doc
+}
+
+// PyExceptionName returns the name of the Python exception class for the Go
+// error name, e.g. NotFoundError for ErrNotFound.
+func PyExceptionName(goName string) string {
+	name := goName
+	if len(name) > 3 && strings.HasPrefix(name, "Err") && unicode.IsUpper(rune(name[3])) {
+		name = name[3:]
+	}
+	if strings.HasSuffix(name, "Error") || strings.HasSuffix(name, "Exception") {
+		return name
+	}
+	return name + "Error"
+}
+
+func IsSentinelErrorExpr(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	ide, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	return (ide.Name == "errors" && sel.Sel.Name == "New") || (ide.Name == "fmt" && sel.Sel.Name == "Errorf")
+}
+
+func ProcessErrorVars(v *doc.Value) []*ErrorSignature {
+	var res []*ErrorSignature
+	for _, spec := range v.Decl.Specs {
+		vspec, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+
+		specDoc := v.Doc
+		if vspec.Doc != nil {
+			specDoc = vspec.Doc.Text()
+		}
+		errDoc, isExport, options := ProcessDoc(specDoc)
+		if !args.ExportAll && !isExport {
+			continue
+		}
+
+		for i, name := range vspec.Names {
+			if !name.IsExported() || i >= len(vspec.Values) || !IsSentinelErrorExpr(vspec.Values[i]) {
+				continue
+			}
+			res = append(res, &ErrorSignature{
+				GoName:      name.Name,
+				PyClassName: PyExceptionName(name.Name),
+				GoDoc:       strings.TrimSpace(errDoc),
+				PyBaseName:  options.Value("base"),
+			})
+		}
+	}
+	return res
+}
+
+// ProcessErrorType returns the exception signature if the type implements the
+// error interface and is exported with a go:pyexport directive.
+func ProcessErrorType(tp *doc.Type) *ErrorSignature {
+	for _, fn := range tp.Methods {
+		if fn.Level != 0 || fn.Name != "Error" {
+			continue
+		}
+		if fn.Decl.Type.Params.NumFields() != 0 || fn.Decl.Type.Results.NumFields() != 1 {
+			continue
+		}
+		if ident, ok := fn.Decl.Type.Results.List[0].Type.(*ast.Ident); !ok || ident.Name != "string" {
+			continue
+		}
+
+		tpDoc, isExport, options := ProcessDoc(tp.Doc)
+		if !args.ExportAll && !isExport {
+			return nil
+		}
+		return &ErrorSignature{
+			GoName:      tp.Name,
+			PyClassName: PyExceptionName(tp.Name),
+			GoDoc:       strings.TrimSpace(tpDoc),
+			PyBaseName:  options.Value("base"),
+			GoAsType:    fn.Recv,
+		}
+	}
+	return nil
+}
+
+// ResolveErrorSignatures sets the C names of the exceptions and sorts them so
+// that the base classes are created first.
+func ResolveErrorSignatures(errSignatures []*ErrorSignature) []*ErrorSignature {
+	byName := make(map[string]*ErrorSignature)
+	for _, es := range errSignatures {
+		if other, ok := byName[es.PyClassName]; ok {
+			log.Fatal().
+				Caller().
+				Msgf("Errors %s and %s are both exported as %s", other.GoName, es.GoName, es.PyClassName)
+		}
+		es.CVarName = "pyexc_" + es.PyClassName
+		byName[es.PyClassName] = es
+	}
+
+	var sorted []*ErrorSignature
+	done := make(map[string]bool)
+	var visit func(es *ErrorSignature, depth int)
+	visit = func(es *ErrorSignature, depth int) {
+		if done[es.PyClassName] {
+			return
+		}
+		if depth > len(errSignatures) {
+			log.Fatal().Caller().Msgf("Cyclic base class for %s", es.GoName)
+		}
+
+		// All the exceptions derive from GoError, either directly or through
+		// their base class
+		switch {
+		case es.PyBaseName == "":
+			es.CBases = "GoError, NULL"
+		case pyBuiltinExceptions[es.PyBaseName]:
+			es.CBases = fmt.Sprintf("PyExc_%s, GoError", es.PyBaseName)
+		case byName[es.PyBaseName] != nil:
+			base := byName[es.PyBaseName]
+			visit(base, depth+1)
+			es.CBases = fmt.Sprintf("%s, NULL", base.CVarName)
+		default:
+			log.Fatal().
+				Caller().
+				Str("error", es.GoName).
+				Msgf("Unknown base exception '%s'", es.PyBaseName)
+		}
+
+		done[es.PyClassName] = true
+		sorted = append(sorted, es)
+	}
+	for _, es := range errSignatures {
+		visit(es, 0)
+	}
+	return sorted
+}
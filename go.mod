@@ -1,14 +1,17 @@
 module github.com/fabgeyer/goserpent
 
-go 1.23
+go 1.23.0
 
 require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/rs/zerolog v1.31.0
+	golang.org/x/tools v0.32.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
-	golang.org/x/sys v0.12.0 // indirect
+	golang.org/x/mod v0.24.0 // indirect
+	golang.org/x/sync v0.13.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
 )
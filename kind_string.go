@@ -41,15 +41,20 @@ func _() {
 	_ = x[Byte-30]
 	_ = x[ByteArray-31]
 	_ = x[NumpyArray-32]
+	_ = x[Seq-33]
+	_ = x[Seq2-34]
+	_ = x[Context-35]
+	_ = x[Enum-36]
 }
 
-const _Kind_name = "InvalidBoolIntInt8Int16Int32Int64UintUint8Uint16Uint32Uint64UintptrFloat32Float64Complex64Complex128ArrayChanFuncInterfaceMapPointerSliceStringStructUnsafePointerNoneErrorCPyObjectPointerByteByteArrayNumpyArray"
+const _Kind_name = "InvalidBoolIntInt8Int16Int32Int64UintUint8Uint16Uint32Uint64UintptrFloat32Float64Complex64Complex128ArrayChanFuncInterfaceMapPointerSliceStringStructUnsafePointerNoneErrorCPyObjectPointerByteByteArrayNumpyArraySeqSeq2ContextEnum"
 
-var _Kind_index = [...]uint8{0, 7, 11, 14, 18, 23, 28, 33, 37, 42, 48, 54, 60, 67, 74, 81, 90, 100, 105, 109, 113, 122, 125, 132, 137, 143, 149, 162, 166, 171, 187, 191, 200, 210}
+var _Kind_index = [...]uint8{0, 7, 11, 14, 18, 23, 28, 33, 37, 42, 48, 54, 60, 67, 74, 81, 90, 100, 105, 109, 113, 122, 125, 132, 137, 143, 149, 162, 166, 171, 187, 191, 200, 210, 213, 217, 224, 228}
 
 func (i Kind) String() string {
-	if i >= Kind(len(_Kind_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_Kind_index)-1 {
 		return "Kind(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _Kind_name[_Kind_index[i]:_Kind_index[i+1]]
+	return _Kind_name[_Kind_index[idx]:_Kind_index[idx+1]]
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/tools/go/packages"
+)
+
+// GoPackage is the Go package exported to Python, with the type information of
+// its source files.
+type GoPackage struct {
+	Name  string
+	Path  string
+	Fset  *token.FileSet
+	Files []*ast.File // Source files, without the files generated by goserpent
+	Types *types.Package
+	Info  *types.Info
+
+	sources map[string][]byte // Content of the source files by filename
+
+	// Named types of other packages referred to by the exported types, by
+	// package path. The generated code needs to import their package.
+	imports map[string]string
+
+	// Structs converted by value, by name. The struct types can refer to
+	// themselves through their fields.
+	structs map[string]*GoType
+}
+
+// LoadPackage loads the Go package matching the patterns, which are either
+// package patterns such as ./pkg/... or import paths, or the Go files of a
+// single package.
+//
+// The dependencies are loaded with go/packages, while the files of the package
+// are parsed and type checked again with the references to cgo faked. The
+// cgo preprocessing would otherwise rewrite the C types, the comments and the
+// positions of the source files.
+func LoadPackage(patterns []string, goTags []string) (*GoPackage, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedTypes,
+	}
+	if len(goTags) > 0 {
+		cfg.BuildFlags = []string{"-tags=" + strings.Join(goTags, ",")}
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(pkgs) == 0:
+		return nil, fmt.Errorf("No Go package matches %s", strings.Join(patterns, " "))
+	case len(pkgs) > 1:
+		var names []string
+		for _, p := range pkgs {
+			names = append(names, p.PkgPath)
+		}
+		return nil, fmt.Errorf("All the exported declarations need to be in the same package, got %s", strings.Join(names, ", "))
+	}
+	lpkg := pkgs[0]
+	if len(lpkg.GoFiles) == 0 {
+		return nil, fmt.Errorf("No Go file in package %s: %v", lpkg.PkgPath, lpkg.Errors)
+	}
+	log.Trace().Msgf("Detected Go package '%s'", lpkg.Name)
+
+	pkg := &GoPackage{
+		Name:    lpkg.Name,
+		Path:    lpkg.PkgPath,
+		Fset:    token.NewFileSet(),
+		sources: make(map[string][]byte),
+		imports: make(map[string]string),
+		structs: make(map[string]*GoType),
+		Info: &types.Info{
+			Types: make(map[ast.Expr]types.TypeAndValue),
+			Defs:  make(map[*ast.Ident]types.Object),
+			Uses:  make(map[*ast.Ident]types.Object),
+		},
+	}
+
+	var files []*ast.File
+	for _, fname := range lpkg.GoFiles {
+		log.Trace().Msgf("Process %s", fname)
+		content, err := os.ReadFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(pkg.Fset, fname, content, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		pkg.sources[fname] = content
+		files = append(files, f)
+		if IsGeneratedFile(f) {
+			log.Trace().Msgf("Skip generated file %s", fname)
+			continue
+		}
+		pkg.Files = append(pkg.Files, f)
+	}
+
+	conf := &types.Config{
+		FakeImportC: true,
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if path == "unsafe" {
+				return types.Unsafe, nil
+			}
+			if imp, ok := lpkg.Imports[path]; ok && imp.Types != nil {
+				return imp.Types, nil
+			}
+			return nil, fmt.Errorf("package %s not found", path)
+		}),
+		// The files refer to the code generated by goserpent, which may not
+		// exist yet. The declarations which cannot be type checked are reported
+		// as not supported.
+		Error: func(err error) {
+			log.Debug().Err(err).Msg("Type checking")
+		},
+	}
+	pkg.Types, _ = conf.Check(lpkg.PkgPath, pkg.Fset, files, pkg.Info)
+	return pkg, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// IsGeneratedFile returns true if the file was generated by goserpent.
+func IsGeneratedFile(f *ast.File) bool {
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if strings.HasPrefix(c.Text, "// Autogenerated by goserpent") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Source returns the source code of the node.
+func (p *GoPackage) Source(node ast.Node) string {
+	file := p.Fset.File(node.Pos())
+	if file == nil {
+		return ""
+	}
+	content := p.sources[file.Name()]
+	return string(content[file.Offset(node.Pos()):file.Offset(node.End())])
+}
+
+// TypeOf returns the type of the expression, or nil if it could not be type
+// checked.
+func (p *GoPackage) TypeOf(expr ast.Expr) types.Type {
+	t := p.Info.TypeOf(expr)
+	if t == nil || t == types.Typ[types.Invalid] {
+		return nil
+	}
+	return t
+}
+
+// IsPredeclared returns true if the expression denotes the predeclared type
+// name, e.g. bool or error.
+func (p *GoPackage) IsPredeclared(expr ast.Expr, name string) bool {
+	t := p.TypeOf(expr)
+	return t != nil && types.Identical(t, types.Universe.Lookup(name).Type())
+}
+
+// IsPointerTo returns true if the expression denotes a pointer to the named
+// type of the package.
+func (p *GoPackage) IsPointerTo(expr ast.Expr, name string) bool {
+	ptr, ok := types.Unalias(p.TypeOf(expr)).(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := types.Unalias(ptr.Elem()).(*types.Named)
+	return ok && named.Obj().Pkg() == p.Types && named.Obj().Name() == name
+}
+
+// TypeString returns the Go code of the type in the generated code, which
+// belongs to the package.
+func (p *GoPackage) TypeString(t types.Type) string {
+	return types.TypeString(t, func(other *types.Package) string {
+		if other == p.Types {
+			return ""
+		}
+		return other.Name()
+	})
+}
+
+// Imports returns the named types of other packages referred to by the types
+// of the package, by package path.
+func (p *GoPackage) Imports() map[string]string {
+	return p.imports
+}
+
+// refer records that the generated code refers to the named type.
+func (p *GoPackage) refer(named *types.Named) error {
+	other := named.Obj().Pkg()
+	if other == nil || other == p.Types {
+		return nil
+	}
+	for path, typeName := range p.imports {
+		if path != other.Path() && strings.HasPrefix(typeName, other.Name()+".") {
+			return fmt.Errorf("Packages %s and %s have the same name", path, other.Path())
+		}
+	}
+	if _, ok := p.imports[other.Path()]; !ok {
+		p.imports[other.Path()] = p.TypeString(named)
+	}
+	return nil
+}
+
+// IsValueType returns true if the named type of the package is converted by
+// value, instead of being exported as a Python type: it has no exported
+// methods, and no function or method of the package returns a pointer to it.
+func (p *GoPackage) IsValueType(named *types.Named) bool {
+	if named.Obj().Pkg() != p.Types || !hasValueUnderlying(named) {
+		return false
+	}
+	ptr := types.NewPointer(named)
+	hasExportedMethods := func(t types.Type) bool {
+		mset := types.NewMethodSet(t)
+		for i := range mset.Len() {
+			if mset.At(i).Obj().Exported() {
+				return true
+			}
+		}
+		return false
+	}
+	if hasExportedMethods(ptr) {
+		return false
+	}
+	returnsPtr := func(fn *types.Func) bool {
+		results := fn.Signature().Results()
+		for i := range results.Len() {
+			if types.Identical(results.At(i).Type(), ptr) {
+				return true
+			}
+		}
+		return false
+	}
+	scope := p.Types.Scope()
+	for _, name := range scope.Names() {
+		switch obj := scope.Lookup(name).(type) {
+		case *types.Func:
+			if returnsPtr(obj) {
+				return false
+			}
+		case *types.TypeName:
+			if other, ok := obj.Type().(*types.Named); ok {
+				for i := range other.NumMethods() {
+					if returnsPtr(other.Method(i)) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// TypeDoc returns the documentation of the type declared by the package.
+func (p *GoPackage) TypeDoc(name string) string {
+	for _, f := range p.Files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok || ts.Name.Name != name {
+					continue
+				}
+				switch {
+				case ts.Doc != nil:
+					return ts.Doc.Text()
+				case gd.Doc != nil && len(gd.Specs) == 1:
+					return gd.Doc.Text()
+				}
+				return ""
+			}
+		}
+	}
+	return ""
+}
+
+// EnumConsts returns the exported constants of the named integer or string type
+// of the package, in the order of their declaration. The types with the
+// go:pyexport enum directive and constants are exported as Python enums.
+func (p *GoPackage) EnumConsts(named *types.Named) []*types.Const {
+	if named.Obj().Pkg() != p.Types || named.TypeArgs().Len() > 0 {
+		return nil
+	}
+	if _, isExport, options := ProcessDoc(p.TypeDoc(named.Obj().Name())); !isExport || !options.Has("enum") {
+		return nil
+	}
+	basic, ok := named.Underlying().(*types.Basic)
+	if !ok || basic.Info()&(types.IsInteger|types.IsString) == 0 {
+		return nil
+	}
+	var res []*types.Const
+	scope := p.Types.Scope()
+	for _, name := range scope.Names() {
+		c, ok := scope.Lookup(name).(*types.Const)
+		if ok && c.Exported() && types.Identical(c.Type(), named) {
+			res = append(res, c)
+		}
+	}
+	slices.SortFunc(res, func(a, b *types.Const) int {
+		return cmp.Compare(a.Pos(), b.Pos())
+	})
+	return res
+}
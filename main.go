@@ -26,10 +26,13 @@ type Args struct {
 	OutputCCode    string   `long:"output-c-code" description:"Output C code file" default:"pyexports.c" required:"true"`
 	OutputChdrCode string   `long:"output-chdr-code" description:"Output C header file" default:"pyexports.h" required:"true"`
 	OutputGoCode   string   `long:"output-go-code" description:"Output Go code file" default:"pyexports.go" required:"true"`
+	OutputPyiStub  string   `long:"output-pyi" description:"Output Python stub file (default: <pymodule>.pyi)"`
+	NoPyiStub      bool     `long:"no-pyi" description:"Do not generate the Python stub file"`
 	PyModuleName   string   `long:"pymodule" description:"Name of the python module" default:"gomodule" required:"true"`
 	GoTags         []string `long:"tags" description:"Go tags for the generated Go code file"`
 	ExportAll      bool     `long:"export-all" description:"Export all functions from the file"`
 	UseSnakeCase   bool     `long:"use-snake-case" description:"Use snake case for the exported functions"`
+	ReleaseGIL     bool     `long:"release-gil" description:"Release the GIL while calling the exported functions"`
 }
 
 func (a *Args) Process() {
@@ -42,10 +45,19 @@ func (a *Args) Process() {
 		zerolog.SetGlobalLevel(zerolog.TraceLevel)
 	}
 
+	if args.NoPyiStub {
+		args.OutputPyiStub = ""
+	} else if args.OutputPyiStub == "" {
+		args.OutputPyiStub = args.PyModuleName + ".pyi"
+	}
+
 	if args.OutputDir != "" {
 		args.OutputCCode = path.Join(args.OutputDir, args.OutputCCode)
 		args.OutputChdrCode = path.Join(args.OutputDir, args.OutputChdrCode)
 		args.OutputGoCode = path.Join(args.OutputDir, args.OutputGoCode)
+		if args.OutputPyiStub != "" {
+			args.OutputPyiStub = path.Join(args.OutputDir, args.OutputPyiStub)
+		}
 	}
 
 	if args.PyModuleName == "" {
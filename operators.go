@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type numberSlot struct {
+	Name string // Name of the Python special method without the underscores
+	Slot string // Field of the PyNumberMethods structure
+}
+
+var binaryOperators = []numberSlot{
+	{"add", "nb_add"},
+	{"sub", "nb_subtract"},
+	{"mul", "nb_multiply"},
+	{"truediv", "nb_true_divide"},
+	{"floordiv", "nb_floor_divide"},
+	{"mod", "nb_remainder"},
+	{"pow", "nb_power"},
+	{"matmul", "nb_matrix_multiply"},
+	{"lshift", "nb_lshift"},
+	{"rshift", "nb_rshift"},
+	{"and", "nb_and"},
+	{"xor", "nb_xor"},
+	{"or", "nb_or"},
+}
+
+var unaryOperators = []numberSlot{
+	{"neg", "nb_negative"},
+	{"pos", "nb_positive"},
+	{"abs", "nb_absolute"},
+	{"invert", "nb_invert"},
+}
+
+// operatorMethodNames maps the Go method names to the operator they implement
+// by convention, when they return a new value of the receiver type. Other
+// methods can implement an operator with the op= option of the go:pyexport
+// directive.
+var operatorMethodNames = map[string]string{
+	"Add": "__add__",
+	"Sub": "__sub__",
+	"Mul": "__mul__",
+	"Div": "__truediv__",
+	"Neg": "__neg__",
+}
+
+// OperatorSlot describes the Go methods implementing a Python operator.
+type OperatorSlot struct {
+	numberSlot
+	Unary bool
+
+	Forward   *OperatorMethod // a + b with a of the exported type
+	Reflected *OperatorMethod // a + b with b of the exported type
+	InPlace   *OperatorMethod // a += b
+}
+
+// OperatorMethod describes a Go method implementing an operator.
+type OperatorMethod struct {
+	GoName       string
+	OtherType    *GoType // Type of the other operand of binary operators
+	ResultType   *GoType // None for in-place methods modifying the receiver
+	ReturnsError bool
+	Explicit     bool
+}
+
+// ReturnsReceiver returns true if the method returns a pointer to the receiver
+// type.
+func (om *OperatorMethod) ReturnsReceiver(typeName string) bool {
+	return om.ResultType.T == Pointer && om.ResultType.GoRepr == typeName
+}
+
+// Ternary returns true for the power operator, whose slot takes an optional
+// modulo argument.
+func (o *OperatorSlot) Ternary() bool {
+	return o.Name == "pow"
+}
+
+func (o *OperatorSlot) InPlaceSlot() string {
+	return "nb_inplace_" + strings.TrimPrefix(o.Slot, "nb_")
+}
+
+// HasSlot returns true if the non in-place slot is implemented.
+func (o *OperatorSlot) HasSlot() bool {
+	return o.Forward != nil || o.Reflected != nil
+}
+
+func (o *OperatorSlot) Methods() []*OperatorMethod {
+	var res []*OperatorMethod
+	for _, om := range []*OperatorMethod{o.Forward, o.Reflected, o.InPlace} {
+		if om != nil {
+			res = append(res, om)
+		}
+	}
+	return res
+}
+
+// GoCall returns the Go code calling the method on the Python object self, and
+// returning the result to Python.
+func (om *OperatorMethod) GoCall(typeName, self, other string) string {
+	call := fmt.Sprintf("%sFromPyObject(%s).%s(%s)", typeName, self, om.GoName, other)
+	var lines []string
+	if om.ResultType.T == None {
+		if om.ReturnsError {
+			lines = append(lines, fmt.Sprintf("if err := %s; err != nil {\nsetPyError(err)\nreturn nil\n}", call))
+		} else {
+			lines = append(lines, call)
+		}
+		lines = append(lines, fmt.Sprintf("return C.PyIncRef(%s)", self))
+	} else {
+		if om.ReturnsError {
+			lines = append(lines, fmt.Sprintf("res, err := %s\nif err != nil {\nsetPyError(err)\nreturn nil\n}", call))
+		} else {
+			lines = append(lines, "res := "+call)
+		}
+		lines = append(lines, om.ResultType.GoPyReturn("res"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ProcessOperators detects the methods of the type implementing operators.
+func ProcessOperators(tp *doc.Type, pkg *GoPackage) []*OperatorSlot {
+	slots := make(map[string]*OperatorSlot)
+	for _, fn := range tp.Methods {
+		if fn.Level != 0 {
+			continue
+		}
+
+		_, _, options := ProcessDoc(fn.Doc)
+		special := options.Value("op")
+		explicit := special != ""
+		if !explicit {
+			special = operatorMethodNames[fn.Name]
+		}
+		if special == "" {
+			continue
+		}
+
+		fatal := func(msg string, v ...any) {
+			if explicit {
+				log.Fatal().
+					Caller().
+					Str("method", tp.Name+"."+fn.Name).
+					Msgf(msg, v...)
+			}
+			log.Trace().Msgf("Skip operator method %s.%s: %s", tp.Name, fn.Name, fmt.Sprintf(msg, v...))
+		}
+
+		ns, unary, variant, ok := lookupOperator(special)
+		if !ok {
+			fatal("Unknown operator %s", special)
+			continue
+		}
+
+		om, err := processOperatorMethod(unary, variant == 'i', fieldTypes(fn.Decl.Type.Params), fieldTypes(fn.Decl.Type.Results), pkg)
+		if err != nil {
+			fatal("Invalid %s method: %v", special, err)
+			continue
+		}
+		if !explicit && !om.ReturnsReceiver(tp.Name) {
+			// Methods such as Add(v int) int modifying the receiver are not
+			// operators
+			log.Trace().Msgf("Skip operator method %s.%s: does not return a %s", tp.Name, fn.Name, tp.Name)
+			continue
+		}
+		om.GoName = fn.Name
+		om.Explicit = explicit
+
+		slot := slots[ns.Name]
+		if slot == nil {
+			slot = &OperatorSlot{numberSlot: ns, Unary: unary}
+			slots[ns.Name] = slot
+		}
+		target := &slot.Forward
+		switch variant {
+		case 'r':
+			target = &slot.Reflected
+		case 'i':
+			target = &slot.InPlace
+		}
+		if *target != nil && (*target).Explicit == explicit {
+			fatal("Operator %s already implemented by %s", special, (*target).GoName)
+			continue
+		}
+		if *target == nil || explicit {
+			*target = om
+		}
+	}
+
+	var res []*OperatorSlot
+	for _, ns := range slices.Concat(binaryOperators, unaryOperators) {
+		if slot, ok := slots[ns.Name]; ok {
+			res = append(res, slot)
+		}
+	}
+	return res
+}
+
+// lookupOperator returns the operator implemented by the special method, and
+// its variant: 'r' for reflected and 'i' for in-place binary operators.
+func lookupOperator(special string) (ns numberSlot, unary bool, variant byte, ok bool) {
+	name, found := strings.CutPrefix(special, "__")
+	if !found {
+		return
+	}
+	name, found = strings.CutSuffix(name, "__")
+	if !found {
+		return
+	}
+
+	for _, ns := range unaryOperators {
+		if ns.Name == name {
+			return ns, true, 0, true
+		}
+	}
+	for _, ns := range binaryOperators {
+		switch name {
+		case ns.Name:
+			return ns, false, 0, true
+		case "r" + ns.Name:
+			return ns, false, 'r', true
+		case "i" + ns.Name:
+			return ns, false, 'i', true
+		}
+	}
+	return
+}
+
+func processOperatorMethod(unary, inPlace bool, params, results []ast.Expr, pkg *GoPackage) (*OperatorMethod, error) {
+	om := &OperatorMethod{}
+
+	if unary {
+		if len(params) != 0 {
+			return nil, fmt.Errorf("unary operators take no argument")
+		}
+	} else {
+		if len(params) != 1 {
+			return nil, fmt.Errorf("binary operators take one argument")
+		}
+		otherType, err := AsGoType(params[0], pkg)
+		if err != nil {
+			return nil, err
+		}
+		if !otherType.IsSettable() {
+			return nil, fmt.Errorf("Type '%s' not supported!", pkg.Source(params[0]))
+		}
+		om.OtherType = otherType
+	}
+
+	if len(results) > 0 && pkg.IsPredeclared(results[len(results)-1], "error") {
+		om.ReturnsError = true
+		results = results[:len(results)-1]
+	}
+	switch {
+	case len(results) == 1:
+		resultType, err := AsGoType(results[0], pkg)
+		if err != nil {
+			return nil, err
+		}
+		om.ResultType = resultType
+	case len(results) == 0 && inPlace:
+		// The in-place operator returns the modified receiver
+		om.ResultType = &GoType{T: None}
+	default:
+		return nil, fmt.Errorf("operators return a single value and optionally an error")
+	}
+	return om, nil
+}
+
+// DropUnexportedOperators removes the operator methods whose operand or result
+// refers to a type which is not exported to Python.
+func (s *TypeSlots) DropUnexportedOperators(typeName string, types map[string]bool) {
+	var operators []*OperatorSlot
+	for _, slot := range s.Operators {
+		for _, target := range []**OperatorMethod{&slot.Forward, &slot.Reflected, &slot.InPlace} {
+			om := *target
+			if om == nil {
+				continue
+			}
+			for _, g := range []*GoType{om.OtherType, om.ResultType} {
+				if g == nil || g.RefersToTypes(types) {
+					continue
+				}
+				if om.Explicit {
+					log.Fatal().
+						Caller().
+						Str("method", typeName+"."+om.GoName).
+						Msgf("Type '%s' is not exported", g.GoTypeName())
+				}
+				log.Trace().Msgf("Skip operator method %s.%s", typeName, om.GoName)
+				*target = nil
+				break
+			}
+		}
+		if len(slot.Methods()) > 0 {
+			operators = append(operators, slot)
+		}
+	}
+	s.Operators = operators
+}
@@ -0,0 +1,180 @@
+"""PEP 517 build backend for Python modules generated with goserpent.
+
+The backend is configured in the ``[tool.goserpent]`` table of the
+project's ``pyproject.toml``::
+
+    [build-system]
+    requires = ["goserpent-backend"]
+    build-backend = "goserpent_backend"
+
+    [project]
+    name = "example"
+    version = "1.0.0"
+
+    [tool.goserpent]
+    module = "example"          # Name of the python module (default: project name)
+    sources = ["example.go"]    # Go files or package pattern with go:pyexport functions (default: all the Go files)
+    tags = ["python"]           # Go build tags
+    export-all = false          # Export all the functions
+    snake-case = false          # Use snake case for the exported functions
+    release-gil = false         # Release the GIL while calling the Go functions
+
+The wheel is built with the ``goserpent wheel`` command. The goserpent
+executable is taken from the ``GOSERPENT`` environment variable, from the
+``PATH``, or run with ``go run`` as a last resort.
+"""
+
+import glob
+import io
+import os
+import re
+import shutil
+import subprocess
+import sys
+import tarfile
+
+try:
+    import tomllib
+except ImportError:  # Python < 3.11
+    import tomli as tomllib
+
+__all__ = [
+    "build_editable",
+    "build_sdist",
+    "build_wheel",
+    "get_requires_for_build_editable",
+    "get_requires_for_build_sdist",
+    "get_requires_for_build_wheel",
+]
+
+GOSERPENT_PACKAGE = "github.com/fabgeyer/goserpent@latest"
+
+# The generated modules use PyModule_AddObjectRef, added in Python 3.10
+REQUIRES_PYTHON = ">=3.10"
+
+# Files and directories which are not included in the source distribution
+SDIST_EXCLUDES = {".git", ".hg", ".svn", "__pycache__", "build", "dist"}
+SDIST_EXCLUDED_SUFFIXES = (".so", ".whl", ".pyc")
+
+
+class Config:
+    def __init__(self, pyproject="pyproject.toml"):
+        with open(pyproject, "rb") as f:
+            data = tomllib.load(f)
+
+        project = data.get("project", {})
+        if "name" not in project or "version" not in project:
+            raise ValueError("pyproject.toml requires a [project] name and version")
+        self.name = project["name"]
+        self.version = project["version"]
+        self.summary = project.get("description", "")
+        self.dependencies = project.get("dependencies", [])
+
+        tool = data.get("tool", {}).get("goserpent", {})
+        self.module = tool.get("module", re.sub(r"[^A-Za-z0-9_]", "_", self.name))
+        self.sources = tool.get("sources") or sorted(
+            fname
+            for fname in glob.glob("*.go")
+            if not fname.endswith("_test.go") and not fname.startswith("pyexports")
+        )
+        self.tags = tool.get("tags", [])
+        self.export_all = tool.get("export-all", False)
+        self.snake_case = tool.get("snake-case", False)
+        self.release_gil = tool.get("release-gil", False)
+
+        if not self.sources:
+            raise ValueError("No Go source file found")
+
+
+def _goserpent_command():
+    goserpent = os.environ.get("GOSERPENT") or shutil.which("goserpent")
+    if goserpent:
+        return [goserpent]
+    return ["go", "run", GOSERPENT_PACKAGE]
+
+
+def build_wheel(wheel_directory, config_settings=None, metadata_directory=None):
+    config = Config()
+
+    cmd = _goserpent_command() + [
+        "wheel",
+        "--pymodule=" + config.module,
+        "--name=" + config.name,
+        "--version=" + config.version,
+        "--wheel-dir=" + os.path.abspath(wheel_directory),
+        "--python=" + sys.executable,
+    ]
+    if config.summary:
+        cmd.append("--summary=" + config.summary)
+    for dependency in config.dependencies:
+        cmd.append("--requires=" + dependency)
+    for tag in config.tags:
+        cmd.append("--tags=" + tag)
+    if config.export_all:
+        cmd.append("--export-all")
+    if config.snake_case:
+        cmd.append("--use-snake-case")
+    if config.release_gil:
+        cmd.append("--release-gil")
+    cmd += config.sources
+
+    before = set(glob.glob(os.path.join(wheel_directory, "*.whl")))
+    subprocess.run(cmd, check=True)
+    built = set(glob.glob(os.path.join(wheel_directory, "*.whl"))) - before
+    if len(built) != 1:
+        raise RuntimeError("goserpent did not build a wheel")
+    return os.path.basename(built.pop())
+
+
+def build_editable(wheel_directory, config_settings=None, metadata_directory=None):
+    # The extension module needs to be compiled, so editable installs are
+    # regular wheels which need to be rebuilt after changes of the Go code.
+    return build_wheel(wheel_directory, config_settings, metadata_directory)
+
+
+def build_sdist(sdist_directory, config_settings=None):
+    config = Config()
+
+    escaped_name = re.sub(r"[^A-Za-z0-9.]+", "_", config.name)
+    basename = "%s-%s" % (escaped_name, config.version)
+    sdist_filename = basename + ".tar.gz"
+
+    pkg_info = [
+        "Metadata-Version: 2.1",
+        "Name: " + config.name,
+        "Version: " + config.version,
+        "Requires-Python: " + REQUIRES_PYTHON,
+    ]
+    if config.summary:
+        pkg_info.append("Summary: " + config.summary)
+    for dependency in config.dependencies:
+        pkg_info.append("Requires-Dist: " + dependency)
+    pkg_info = ("\n".join(pkg_info) + "\n").encode("utf8")
+
+    with tarfile.open(os.path.join(sdist_directory, sdist_filename), "w:gz", format=tarfile.PAX_FORMAT) as tar:
+        for root, dirs, files in os.walk("."):
+            dirs[:] = sorted(d for d in dirs if d not in SDIST_EXCLUDES and not d.startswith("."))
+            for fname in sorted(files):
+                if fname.endswith(SDIST_EXCLUDED_SUFFIXES) or fname == "PKG-INFO":
+                    continue
+                path = os.path.normpath(os.path.join(root, fname))
+                tar.add(path, arcname=os.path.join(basename, path))
+
+        info = tarfile.TarInfo(os.path.join(basename, "PKG-INFO"))
+        info.size = len(pkg_info)
+        info.mode = 0o644
+        tar.addfile(info, io.BytesIO(pkg_info))
+
+    return sdist_filename
+
+
+def get_requires_for_build_wheel(config_settings=None):
+    return []
+
+
+def get_requires_for_build_editable(config_settings=None):
+    return []
+
+
+def get_requires_for_build_sdist(config_settings=None):
+    return []
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TypeSlots lists the Go methods of a type which implement Python special
+// methods through the slots of its PyTypeObject.
+type TypeSlots struct {
+	HasString bool // String() string -> __str__ and __repr__
+	HasEqual  bool // Equal(*T) bool -> __eq__ and __ne__
+	HasHash   bool // Hash() uint64 -> __hash__
+	HasLess   bool // Less(*T) bool -> __lt__, __le__, __gt__ and __ge__
+
+	// Close() or Close() error -> __enter__ and __exit__
+	HasClose          bool
+	CloseReturnsError bool
+
+	// Container protocols
+	Len      *SlotMethod // Len() int -> __len__
+	GetItem  *SlotMethod // At(int) T or Get(K) (V, bool) -> __getitem__
+	SetItem  *SlotMethod // Set(K, V) -> __setitem__
+	DelItem  *SlotMethod // Delete(K) -> __delitem__
+	Contains *SlotMethod // Contains(K) bool, or Get(K) (V, bool) -> __contains__
+	Iter     *SlotMethod // All() iter.Seq[T] or iter.Seq2[K, V] -> __iter__
+
+	// The items are accessed by their index, like a Python sequence
+	IndexKeys bool
+
+	// Number protocol
+	Operators []*OperatorSlot
+}
+
+// SlotMethod describes a Go method implementing a container special method.
+type SlotMethod struct {
+	GoName       string
+	KeyType      *GoType
+	ValueType    *GoType
+	ReturnsOk    bool // Returns a bool reporting if the item exists
+	ReturnsError bool
+	Explicit     bool
+}
+
+// Hashable returns false if the type defines its equality without a hash, as
+// equal objects need to have the same hash.
+func (s TypeSlots) Hashable() bool {
+	return s.HasHash || !s.HasEqual
+}
+
+func (s TypeSlots) IsSequence() bool {
+	return s.Len != nil || (s.GetItem != nil && s.IndexKeys) || s.Contains != nil
+}
+
+func (s TypeSlots) IsMapping() bool {
+	return s.Len != nil || s.GetItem != nil || s.SetItem != nil || s.DelItem != nil
+}
+
+var slotSpecialMethods = []string{"__len__", "__getitem__", "__setitem__", "__delitem__", "__contains__", "__iter__"}
+
+// slotMethodNames maps the Go method names to the special method they
+// implement. The special method can also be given with a go:pyexport directive.
+var slotMethodNames = map[string]string{
+	"Len":      "__len__",
+	"At":       "__getitem__",
+	"Get":      "__getitem__",
+	"Set":      "__setitem__",
+	"Delete":   "__delitem__",
+	"Contains": "__contains__",
+	"All":      "__iter__",
+}
+
+// fieldTypes returns the type of each parameter or result of the field list.
+func fieldTypes(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var res []ast.Expr
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for range n {
+			res = append(res, field.Type)
+		}
+	}
+	return res
+}
+
+// ProcessSlots detects the methods of the type with a well-known shape.
+func ProcessSlots(tp *doc.Type, pkg *GoPackage) TypeSlots {
+	var slots TypeSlots
+	for _, fn := range tp.Methods {
+		if fn.Level != 0 {
+			continue
+		}
+		params := fieldTypes(fn.Decl.Type.Params)
+		results := fieldTypes(fn.Decl.Type.Results)
+
+		switch fn.Name {
+		case "String":
+			slots.HasString = len(params) == 0 && len(results) == 1 && pkg.IsPredeclared(results[0], "string")
+		case "Equal":
+			slots.HasEqual = len(params) == 1 && len(results) == 1 && pkg.IsPointerTo(params[0], tp.Name) && pkg.IsPredeclared(results[0], "bool")
+		case "Hash":
+			slots.HasHash = len(params) == 0 && len(results) == 1 && pkg.IsPredeclared(results[0], "uint64")
+		case "Less":
+			slots.HasLess = len(params) == 1 && len(results) == 1 && pkg.IsPointerTo(params[0], tp.Name) && pkg.IsPredeclared(results[0], "bool")
+		case "Close":
+			slots.HasClose = len(params) == 0 && (len(results) == 0 || (len(results) == 1 && pkg.IsPredeclared(results[0], "error")))
+			slots.CloseReturnsError = slots.HasClose && len(results) == 1
+		}
+
+		_, _, options := ProcessDoc(fn.Doc)
+		special, explicit := "", false
+		for _, opt := range options {
+			if slices.Contains(slotSpecialMethods, opt) {
+				special, explicit = opt, true
+			}
+		}
+		if special == "" {
+			special = slotMethodNames[fn.Name]
+		}
+		if special == "" {
+			continue
+		}
+
+		sm, err := processSlotMethod(special, params, results, pkg)
+		if err != nil {
+			if explicit {
+				log.Fatal().
+					Caller().
+					Str("method", tp.Name+"."+fn.Name).
+					Err(err).
+					Msgf("Invalid %s method", special)
+			}
+			log.Trace().Err(err).Msgf("Skip %s method %s.%s", special, tp.Name, fn.Name)
+			continue
+		}
+		sm.GoName = fn.Name
+		sm.Explicit = explicit
+
+		var slot **SlotMethod
+		switch special {
+		case "__len__":
+			slot = &slots.Len
+		case "__getitem__":
+			slot = &slots.GetItem
+		case "__setitem__":
+			slot = &slots.SetItem
+		case "__delitem__":
+			slot = &slots.DelItem
+		case "__contains__":
+			slot = &slots.Contains
+		case "__iter__":
+			slot = &slots.Iter
+		}
+		// Methods with an explicit directive take precedence, then At over Get
+		if *slot == nil || (explicit && !(*slot).Explicit) || (!(*slot).Explicit && fn.Name == "At") {
+			*slot = sm
+		}
+	}
+
+	if slots.GetItem != nil {
+		slots.IndexKeys = slots.GetItem.KeyType.IsInteger() && (slots.GetItem.GoName == "At" || slots.GetItem.Explicit)
+		if slots.Contains == nil && slots.GetItem.ReturnsOk && !slots.IndexKeys {
+			slots.Contains = slots.GetItem
+		}
+	}
+	slots.Operators = ProcessOperators(tp, pkg)
+	return slots
+}
+
+// processSlotMethod checks the signature of a method implementing the special
+// method, and returns the Go types of its key and value.
+func processSlotMethod(special string, params, results []ast.Expr, pkg *GoPackage) (*SlotMethod, error) {
+	sm := &SlotMethod{}
+	var err error
+	asGoType := func(expr ast.Expr) *GoType {
+		if err != nil {
+			return nil
+		}
+		var g *GoType
+		g, err = AsGoType(expr, pkg)
+		if err == nil && !g.IsSettable() {
+			err = fmt.Errorf("Type '%s' not supported!", pkg.Source(expr))
+		}
+		return g
+	}
+	// Checks the optional last result reporting if the item exists or an error
+	status := func(results []ast.Expr) bool {
+		switch {
+		case len(results) == 0:
+		case len(results) == 1 && pkg.IsPredeclared(results[0], "bool"):
+			sm.ReturnsOk = true
+		case len(results) == 1 && pkg.IsPredeclared(results[0], "error"):
+			sm.ReturnsError = true
+		default:
+			return false
+		}
+		return true
+	}
+
+	switch special {
+	case "__len__":
+		if len(params) != 0 || len(results) != 1 || !pkg.IsPredeclared(results[0], "int") {
+			return nil, fmt.Errorf("%s requires the signature func() int", special)
+		}
+
+	case "__getitem__":
+		if len(params) != 1 || len(results) == 0 || !status(results[1:]) {
+			return nil, fmt.Errorf("%s requires the signature func(K) V, func(K) (V, bool) or func(K) (V, error)", special)
+		}
+		sm.KeyType = asGoType(params[0])
+		sm.ValueType, _ = AsGoType(results[0], pkg)
+		if err == nil && sm.ValueType == nil {
+			err = fmt.Errorf("Type '%s' not supported!", pkg.Source(results[0]))
+		}
+
+	case "__setitem__":
+		if len(params) != 2 || !status(results) || sm.ReturnsOk {
+			return nil, fmt.Errorf("%s requires the signature func(K, V) or func(K, V) error", special)
+		}
+		sm.KeyType = asGoType(params[0])
+		sm.ValueType = asGoType(params[1])
+
+	case "__delitem__":
+		if len(params) != 1 || !status(results) {
+			return nil, fmt.Errorf("%s requires the signature func(K), func(K) bool or func(K) error", special)
+		}
+		sm.KeyType = asGoType(params[0])
+
+	case "__contains__":
+		if len(params) != 1 || len(results) != 1 || !pkg.IsPredeclared(results[0], "bool") {
+			return nil, fmt.Errorf("%s requires the signature func(K) bool", special)
+		}
+		sm.KeyType = asGoType(params[0])
+
+	case "__iter__":
+		if len(params) == 0 && len(results) == 1 {
+			sm.ValueType, err = AsGoType(results[0], pkg)
+		}
+		if sm.ValueType == nil || (sm.ValueType.T != Seq && sm.ValueType.T != Seq2) {
+			return nil, fmt.Errorf("%s requires the signature func() iter.Seq[T] or func() iter.Seq2[K, V]", special)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// SlotMethods returns the methods implementing the container special methods.
+func (s *TypeSlots) SlotMethods() []*SlotMethod {
+	var res []*SlotMethod
+	for _, sm := range []*SlotMethod{s.Len, s.GetItem, s.SetItem, s.DelItem, s.Contains, s.Iter} {
+		if sm != nil {
+			res = append(res, sm)
+		}
+	}
+	return res
+}
+
+// DropUnexported removes the methods whose key or value refers to a type which
+// is not exported to Python.
+func (s *TypeSlots) DropUnexported(typeName string, types map[string]bool) {
+	for _, slot := range []**SlotMethod{&s.Len, &s.GetItem, &s.SetItem, &s.DelItem, &s.Contains, &s.Iter} {
+		sm := *slot
+		if sm == nil {
+			continue
+		}
+		for _, g := range []*GoType{sm.KeyType, sm.ValueType} {
+			if g == nil || g.RefersToTypes(types) {
+				continue
+			}
+			if sm.Explicit {
+				log.Fatal().
+					Caller().
+					Str("method", typeName+"."+sm.GoName).
+					Msgf("Type '%s' is not exported", g.GoTypeName())
+			}
+			log.Trace().Msgf("Skip method %s.%s", typeName, sm.GoName)
+			*slot = nil
+			break
+		}
+	}
+	if s.GetItem == nil {
+		s.IndexKeys = false
+	}
+	s.DropUnexportedOperators(typeName, types)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StructSignature describes a Python dataclass generated from a Go struct
+// converted by value (type Point struct{ X, Y float64 }).
+type StructSignature struct {
+	*GoType
+	GoDoc string
+
+	CVarName string
+}
+
+func NewStructSignature(g *GoType, pkg *GoPackage) *StructSignature {
+	structDoc, _, _ := ProcessDoc(pkg.TypeDoc(g.GoRepr))
+	return &StructSignature{
+		GoType:   g,
+		GoDoc:    strings.TrimSpace(structDoc),
+		CVarName: "pystruct_" + g.GoRepr,
+	}
+}
+
+// PyFieldNames returns the names of the fields of the dataclass.
+func (ss *StructSignature) PyFieldNames() []string {
+	var names []string
+	for _, f := range ss.StructFields {
+		names = append(names, f.PyName)
+	}
+	return names
+}
+
+func (ss *StructSignature) CDoc() string {
+	if ss.GoDoc == "" {
+		return "NULL"
+	}
+	doc, err := CCodeString(ss.GoDoc)
+	if err != nil {
+		log.Fatal().
+			Caller().
+			Str("struct", ss.GoRepr).
+			Err(err).
+			Msg("Could not generate documentation")
+	}
+	return doc
+}
@@ -7,18 +7,30 @@ package main
 import "C"
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Automatically exported as it returns a *C.PyObject
 func FunctionWithArgs(arg1, arg2 int, arg3 string) *C.PyObject {
 	fmt.Printf("FunctionWithArgs(%d, %d, %s)\n", arg1, arg2, arg3)
+	C.Py_IncRef(C.Py_None)
 	return C.Py_None
 }
 
 // Automatically exported as it returns a *C.PyObject
 func BasicFunction() *C.PyObject {
 	fmt.Println("BasicFunction()")
+	C.Py_IncRef(C.Py_None)
 	return C.Py_None
 }
 
@@ -92,6 +104,75 @@ func FunctionReturnBytes() []byte {
 	return []byte("Hello world!")
 }
 
+// go:pyexport nogil
+func FunctionReleasingGIL(n int, values []int) int {
+	var sum int
+	for i := range n {
+		sum += values[i%len(values)]
+	}
+	return sum
+}
+
+// go:pyexport
+func FunctionPanics(arg int) int {
+	values := []int{1, 2, 3}
+	return values[arg]
+}
+
+// ErrNotFound is returned when a key is missing.
+//
+// go:pyexport base=KeyError
+var ErrNotFound = errors.New("not found")
+
+// go:pyexport base=TimeoutError
+var ErrTimeout = errors.New("timeout")
+
+// ErrUserNotFound is a more specific ErrNotFound.
+//
+// go:pyexport base=NotFoundError
+var ErrUserNotFound = fmt.Errorf("user %w", ErrNotFound)
+
+// go:pyexport
+var ErrIO = errors.New("i/o error")
+
+// ErrQuotaExceeded wraps ErrIO without deriving from its exception class.
+//
+// go:pyexport
+var ErrQuotaExceeded = fmt.Errorf("quota exceeded: %w", ErrIO)
+
+// ErrInternal is not exported as an exception without a go:pyexport directive.
+var ErrInternal = errors.New("internal")
+
+// ValidationError is returned when a value is invalid.
+//
+// go:pyexport base=ValueError
+type ValidationError struct {
+	Value int
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value %d", e.Value)
+}
+
+// go:pyexport
+func FunctionReturnCustomError(arg int) (int, error) {
+	switch arg {
+	case 0:
+		return 0, fmt.Errorf("lookup %d: %w", arg, ErrNotFound)
+	case 1:
+		return 0, ErrTimeout
+	case 2:
+		return 0, &ValidationError{Value: arg}
+	case 4:
+		return 0, fmt.Errorf("lookup %d: %w", arg, ErrUserNotFound)
+	case 5:
+		return 0, ErrInternal
+	case 6:
+		return 0, fmt.Errorf("write: %w", ErrQuotaExceeded)
+	}
+	return arg, nil
+}
+
 type ExportedType struct {
 	Value int
 }
@@ -111,6 +192,18 @@ func NewExportedTypes(x, y int) []*ExportedType {
 	}
 }
 
+// FunctionSumValues takes pointers to an exported type as arguments.
+//
+// go:pyexport
+func FunctionSumValues(a, b *ExportedType) int {
+	return a.Value + b.Value
+}
+
+// go:pyexport
+func (t *ExportedType) Self() *ExportedType {
+	return t
+}
+
 // go:pyexport
 func (t *ExportedType) GetValue() int {
 	return t.Value
@@ -121,3 +214,652 @@ func (t *ExportedType) Add(v int) int {
 	t.Value += v
 	return t.Value
 }
+
+// ExportedRecord is a record whose fields are exported with struct tags.
+//
+// go:pyexport identity
+type ExportedRecord struct {
+	// Name of the record
+	Name    string    `pyexport:"name"`
+	Count   int       `pyexport:"count,readonly"`
+	Weights []float64 `pyexport:"weights"`
+	Hidden  int
+}
+
+// go:pyexport
+func NewExportedRecord(name string) *ExportedRecord {
+	return &ExportedRecord{Name: name}
+}
+
+// MakeExportedRecord creates a record with the given weights.
+//
+// go:pyexport constructor
+func MakeExportedRecord(name string, weights []float64) (*ExportedRecord, error) {
+	if name == "" {
+		return nil, errors.New("empty name")
+	}
+	return &ExportedRecord{Name: name, Weights: weights}, nil
+}
+
+// go:pyexport
+func (r *ExportedRecord) Self() *ExportedRecord {
+	return r
+}
+
+// go:pyexport
+func (r *ExportedRecord) Increment() int {
+	r.Count++
+	return r.Count
+}
+
+// Version implements the methods mapped to the Python special methods.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// go:pyexport
+func NewVersion(major, minor int) *Version {
+	return &Version{Major: major, Minor: minor}
+}
+
+func (v *Version) String() string {
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+func (v *Version) Equal(other *Version) bool {
+	return v.Major == other.Major && v.Minor == other.Minor
+}
+
+func (v *Version) Hash() uint64 {
+	return uint64(v.Major)<<32 | uint64(v.Minor)
+}
+
+func (v *Version) Less(other *Version) bool {
+	return v.Major < other.Major || (v.Major == other.Major && v.Minor < other.Minor)
+}
+
+// IntList implements the Python sequence protocol.
+type IntList struct {
+	values []int
+}
+
+// go:pyexport
+func NewIntList(values []int) *IntList {
+	return &IntList{values: values}
+}
+
+func (l *IntList) Len() int {
+	return len(l.values)
+}
+
+func (l *IntList) At(i int) int {
+	return l.values[i]
+}
+
+func (l *IntList) Set(i int, v int) {
+	l.values[i] = v
+}
+
+func (l *IntList) All() iter.Seq[int] {
+	return slices.Values(l.values)
+}
+
+// FloatMap implements the Python mapping protocol.
+type FloatMap struct {
+	values map[string]float64
+}
+
+// go:pyexport
+func NewFloatMap() *FloatMap {
+	return &FloatMap{values: make(map[string]float64)}
+}
+
+func (m *FloatMap) Len() int {
+	return len(m.values)
+}
+
+func (m *FloatMap) Get(k string) (float64, bool) {
+	v, ok := m.values[k]
+	return v, ok
+}
+
+func (m *FloatMap) Set(k string, v float64) {
+	m.values[k] = v
+}
+
+func (m *FloatMap) Delete(k string) bool {
+	_, ok := m.values[k]
+	delete(m.values, k)
+	return ok
+}
+
+func (m *FloatMap) All() iter.Seq[string] {
+	return maps.Keys(m.values)
+}
+
+// Registry uses a directive to implement __getitem__.
+type Registry struct{}
+
+// go:pyexport
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// go:pyexport __getitem__
+func (r *Registry) Lookup(name string) (string, error) {
+	if name == "" {
+		return "", ErrNotFound
+	}
+	return "registered " + name, nil
+}
+
+// Vector implements Python operators.
+type Vector struct {
+	X, Y float64
+}
+
+// go:pyexport
+func NewVector(x, y float64) *Vector {
+	return &Vector{X: x, Y: y}
+}
+
+func (v *Vector) Add(other *Vector) *Vector {
+	return &Vector{v.X + other.X, v.Y + other.Y}
+}
+
+func (v *Vector) Sub(other *Vector) *Vector {
+	return &Vector{v.X - other.X, v.Y - other.Y}
+}
+
+func (v *Vector) Mul(f float64) *Vector {
+	return &Vector{v.X * f, v.Y * f}
+}
+
+func (v *Vector) Neg() *Vector {
+	return &Vector{-v.X, -v.Y}
+}
+
+// go:pyexport op=__rmul__
+func (v *Vector) Scale(f float64) *Vector {
+	return v.Mul(f)
+}
+
+// go:pyexport op=__iadd__
+func (v *Vector) AddAssign(other *Vector) {
+	v.X += other.X
+	v.Y += other.Y
+}
+
+// go:pyexport op=__truediv__
+func (v *Vector) DivBy(f float64) (*Vector, error) {
+	if f == 0 {
+		return nil, errors.New("division by zero")
+	}
+	return v.Mul(1 / f), nil
+}
+
+// Database is closed at the end of a Python with block.
+type Database struct {
+	Name   string
+	path   string
+	keys   []string
+	closed bool
+}
+
+// go:pyexport
+func OpenDB(path string) (*Database, error) {
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	return &Database{path: path}, nil
+}
+
+// go:pyexport
+func (db *Database) Query(key string) string {
+	return db.path + ":" + key
+}
+
+// go:pyexport
+func (db *Database) Len() int {
+	return len(db.keys)
+}
+
+// Table returns the database of a table.
+//
+// go:pyexport op=__truediv__
+func (db *Database) Table(name string) *Database {
+	return &Database{Name: name, path: db.path + "/" + name}
+}
+
+func (db *Database) String() string {
+	return "Database(" + db.path + ")"
+}
+
+// go:pyexport
+func (db *Database) IsClosed() bool {
+	return db.closed
+}
+
+// go:pyexport
+func (db *Database) Close() error {
+	db.closed = true
+	if db.path == "fail" {
+		return errors.New("close failed")
+	}
+	return nil
+}
+
+// countProduced and countStopped record the progress of the last sequence
+// returned by FunctionCount.
+var countProduced, countStopped int
+
+// FunctionCount lazily counts from 0 to n excluded.
+//
+// go:pyexport
+func FunctionCount(n int) iter.Seq[int] {
+	countProduced, countStopped = 0, 0
+	return func(yield func(int) bool) {
+		defer func() { countStopped++ }()
+		for i := range n {
+			countProduced++
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+// go:pyexport
+func FunctionCountState() []int {
+	return []int{countProduced, countStopped}
+}
+
+// go:pyexport
+func FunctionEnumerate(values []string) iter.Seq2[int, string] {
+	return slices.All(values)
+}
+
+// go:pyexport
+func (l *IntList) Backward() iter.Seq2[int, int] {
+	return slices.Backward(l.values)
+}
+
+// sendFinished records if the goroutine started by the last FunctionSendValues
+// call has finished sending.
+var sendFinished atomic.Bool
+
+// FunctionSendValues sends the values from 0 to n excluded, waiting delay
+// milliseconds before each value, until the context is cancelled.
+//
+// go:pyexport
+func FunctionSendValues(ctx context.Context, n int, delay int) <-chan int {
+	sendFinished.Store(false)
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		defer sendFinished.Store(true)
+		for i := range n {
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// go:pyexport
+func FunctionSendFinished() bool {
+	return sendFinished.Load()
+}
+
+// FunctionSlowSquare returns the square of v after delay milliseconds.
+//
+// go:pyexport async
+func FunctionSlowSquare(v int, delay int) (int, error) {
+	time.Sleep(time.Duration(delay) * time.Millisecond)
+	if v < 0 {
+		return 0, &ValidationError{Value: v}
+	}
+	return v * v, nil
+}
+
+// go:pyexport async
+func FunctionAsyncPanics(arg int) int {
+	values := []int{1, 2, 3}
+	return values[arg]
+}
+
+// lastContextError records the error of the context which ended the last
+// FunctionWaitContext, FunctionWaitContextAsync or FunctionTicks call.
+var lastContextError atomic.Value
+
+// FunctionWaitContext returns delay after delay milliseconds, unless the context
+// is cancelled first.
+//
+// go:pyexport
+func FunctionWaitContext(ctx context.Context, delay int) (int, error) {
+	lastContextError.Store("")
+	select {
+	case <-time.After(time.Duration(delay) * time.Millisecond):
+		return delay, nil
+	case <-ctx.Done():
+		lastContextError.Store(ctx.Err().Error())
+		return 0, ctx.Err()
+	}
+}
+
+// go:pyexport async
+func FunctionWaitContextAsync(ctx context.Context, delay int) (int, error) {
+	return FunctionWaitContext(ctx, delay)
+}
+
+// FunctionTicks sends increasing values until the context is cancelled.
+//
+// go:pyexport
+func FunctionTicks(ctx context.Context) <-chan int {
+	lastContextError.Store("")
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 0; ; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				lastContextError.Store(ctx.Err().Error())
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// go:pyexport
+func FunctionLastContextError() string {
+	return lastContextError.Load().(string)
+}
+
+// FunctionFilter returns the values for which keep returns true.
+//
+// go:pyexport
+func FunctionFilter(values []int, label string, keep func(int, string) (bool, error)) ([]int, error) {
+	var res []int
+	for _, v := range values {
+		ok, err := keep(v, label)
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %w", v, err)
+		}
+		if ok {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+// FunctionApply returns the result of fn applied to v, or v if fn is nil.
+//
+// go:pyexport
+func FunctionApply(v float64, fn func(float64) float64) float64 {
+	if fn == nil {
+		return v
+	}
+	return fn(v)
+}
+
+// FunctionApplyConcurrently calls fn from n goroutines and returns the sum of
+// the results.
+//
+// go:pyexport nogil
+func FunctionApplyConcurrently(n int, fn func(int) int) int {
+	results := make(chan int)
+	for i := range n {
+		go func() { results <- fn(i) }()
+	}
+	sum := 0
+	for range n {
+		sum += <-results
+	}
+	return sum
+}
+
+// FunctionMakeScorer returns a func scoring the names starting with prefix.
+//
+// go:pyexport
+func FunctionMakeScorer(prefix string, weight float64) func(name string) float64 {
+	return func(name string) float64 {
+		if strings.HasPrefix(name, prefix) {
+			return weight * float64(len(name))
+		}
+		return 0
+	}
+}
+
+// go:pyexport
+func FunctionMakeDivider() func(int, int) (int, error) {
+	return func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, &ValidationError{Value: b}
+		}
+		return a / b, nil
+	}
+}
+
+// FunctionMakeFunc returns nil if valid is false.
+//
+// go:pyexport
+func FunctionMakeFunc(valid bool) func() {
+	if !valid {
+		return nil
+	}
+	return func() {}
+}
+
+// FunctionTwice returns a func calling fn twice.
+//
+// go:pyexport
+func FunctionTwice(fn func(int) int) func(int) int {
+	return func(v int) int {
+		return fn(fn(v))
+	}
+}
+
+// Celsius is a temperature in degrees Celsius.
+type Celsius float64
+
+type Tag string
+
+type Tags []string
+
+type Labels map[string]string
+
+type Switch bool
+
+// FunctionWarmer returns the temperature increased by delta.
+//
+// go:pyexport
+func FunctionWarmer(c Celsius, delta Celsius) Celsius {
+	return c + delta
+}
+
+// go:pyexport
+func FunctionPrefixTags(tags Tags, prefix Tag) Tags {
+	res := make(Tags, len(tags))
+	for i, tag := range tags {
+		res[i] = string(prefix) + tag
+	}
+	return res
+}
+
+// go:pyexport
+func FunctionLabelKeys(labels Labels) []Tag {
+	var res []Tag
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		res = append(res, Tag(k))
+	}
+	return res
+}
+
+// go:pyexport
+func FunctionMakeLabels(tags []Tag, value Tag) Labels {
+	res := make(Labels)
+	for _, tag := range tags {
+		res[string(tag)] = string(value)
+	}
+	return res
+}
+
+// go:pyexport
+func FunctionToggle(s Switch) Switch {
+	return !s
+}
+
+// go:pyexport
+func FunctionDoubleDuration(d time.Duration) time.Duration {
+	return 2 * d
+}
+
+// Mode is the access mode of a file.
+//
+// go:pyexport enum
+type Mode int
+
+const (
+	ModeRead Mode = iota
+	ModeWrite
+	ModeReadWrite
+)
+
+func (m Mode) String() string {
+	return [...]string{"r", "w", "rw"}[m]
+}
+
+// Color is exported with upper case member names.
+//
+// go:pyexport enum names=upper
+type Color string
+
+const (
+	ColorDarkRed Color = "dark-red"
+	ColorBlue    Color = "blue"
+	ColorDefault       = ColorBlue
+)
+
+// go:pyexport
+func FunctionNextMode(m Mode) Mode {
+	return (m + 1) % 3
+}
+
+// go:pyexport
+func FunctionInvalidMode() Mode {
+	return Mode(42)
+}
+
+// Flags are combined with a bitwise or. Without the enum directive, they are
+// converted as integers.
+type Flags int
+
+const (
+	FlagHidden Flags = 1 << iota
+	FlagSystem
+)
+
+// go:pyexport
+func FunctionCombineFlags(a, b Flags) Flags {
+	return a | b
+}
+
+// go:pyexport
+func FunctionCountColors(colors []Color) map[Color]int {
+	res := make(map[Color]int)
+	for _, c := range colors {
+		res[c]++
+	}
+	return res
+}
+
+// MaxItems is the maximum number of items.
+//
+// go:pyexport
+const MaxItems = 100
+
+// go:pyexport
+const (
+	DefaultName                = "gopher"
+	DefaultTemperature Celsius = 21.5
+)
+
+// Counter is incremented by FunctionIncrementCounter.
+//
+// go:pyexport live
+var Counter int
+
+// go:pyexport
+var (
+	Greetings   = []string{"hello", "bonjour"}
+	DefaultMode = ModeWrite
+)
+
+// go:pyexport
+func FunctionIncrementCounter() {
+	Counter++
+}
+
+// Point is a point in the plane.
+type Point struct {
+	X, Y float64
+}
+
+// Segment is a labelled segment between two points.
+type Segment struct {
+	From  Point  `json:"from"`
+	To    Point  `py:"to"`
+	Label string `json:"label,omitempty"`
+	Mode  Mode
+	Tags  map[string][]Point
+	Ref   string `json:"ref-id"`
+	notes string
+}
+
+// go:pyexport
+var Origin = Point{}
+
+// go:pyexport
+func FunctionMidpoint(a, b Point) Point {
+	return Point{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2}
+}
+
+// go:pyexport
+func FunctionTranslate(points []Point, dx, dy float64) []Point {
+	res := make([]Point, len(points))
+	for i, p := range points {
+		res[i] = Point{X: p.X + dx, Y: p.Y + dy}
+	}
+	return res
+}
+
+// go:pyexport
+func FunctionMakeSegment(a, b Point, label string) Segment {
+	return Segment{From: a, To: b, Label: label, Mode: ModeWrite, Tags: map[string][]Point{"ends": {a, b}}}
+}
+
+// go:pyexport
+func FunctionSegmentLength(s Segment) float64 {
+	return math.Hypot(s.To.X-s.From.X, s.To.Y-s.From.Y)
+}
+
+// FunctionGoMemStats returns the size of the Go heap and the number of cgo
+// handles held by Python objects.
+//
+// go:pyexport
+func FunctionGoMemStats() map[string]int {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return map[string]int{
+		"heap":    int(m.HeapAlloc),
+		"handles": int(pyObjectHandles.Load()),
+	}
+}
@@ -1,5 +1,22 @@
+import ast
+import _thread
+import asyncio
+import dataclasses
+import enum
+import inspect
+import threading
+import time
+import weakref
 import testmodule as tm
 
+# Check that the generated stub file is valid
+with open("testmodule.pyi") as f:
+    stub = ast.parse(f.read())
+stub_names = {node.name for node in stub.body if isinstance(node, (ast.ClassDef, ast.FunctionDef))}
+assert {"FunctionWithArgs", "ExportedType", "NewExportedType", "NotFoundError", "GoPanicError"} <= stub_names
+stub_classes = {node.name: node for node in stub.body if isinstance(node, ast.ClassDef)}
+assert ast.get_docstring(stub_classes["ExportedRecord"], clean=False) == "ExportedRecord is a record whose fields are exported with struct tags."
+
 assert tm.FunctionWithArgs(1, 2, "hello") is None
 
 assert tm.BasicFunction() is None
@@ -42,7 +59,696 @@ assert tm.FunctionListArgument(list(range(10))) == 45
 
 assert tm.FunctionReturnBytes().decode("utf8") == "Hello world!"
 
+assert tm.FunctionPanics(1) == 2
+
+try:
+    tm.FunctionPanics(42)
+except tm.GoPanicError as e:
+    assert isinstance(e, RuntimeError)
+    assert "index out of range" in str(e)
+    assert "FunctionPanics" in e.go_stack
+else:
+    raise Exception("Function did not throw an error")
+
+try:
+    tm.FunctionListArgument([1, "a"])
+except TypeError as e:
+    assert str(e) == "argument 'values': expected int, got str", str(e)
+else:
+    raise Exception("Function did not throw an error")
+
+try:
+    tm.FunctionMapArgument([1], "a")
+except TypeError as e:
+    assert str(e) == "argument 'arg': expected dict, got list", str(e)
+else:
+    raise Exception("Function did not throw an error")
+
+assert tm.FunctionReturnCustomError(3) == 3
+
+try:
+    tm.FunctionReturnCustomError(0)
+except tm.NotFoundError as e:
+    assert isinstance(e, KeyError)
+    assert isinstance(e, tm.GoError)
+    assert isinstance(e, RuntimeError)
+    assert e.args == ("lookup 0: not found",)
+else:
+    raise Exception("Function did not throw an error")
+
+try:
+    tm.FunctionReturnCustomError(1)
+except TimeoutError as e:
+    assert isinstance(e, tm.TimeoutError)
+    assert str(e) == "timeout"
+else:
+    raise Exception("Function did not throw an error")
+
+try:
+    tm.FunctionReturnCustomError(2)
+except ValueError as e:
+    assert isinstance(e, tm.ValidationError)
+    assert str(e) == "invalid value 2"
+    assert tm.ValidationError.__doc__ == "ValidationError is returned when a value is invalid."
+else:
+    raise Exception("Function did not throw an error")
+
+# A wrapped error matching a derived and a base exception raises the derived one
+try:
+    tm.FunctionReturnCustomError(4)
+except tm.UserNotFoundError as e:
+    assert isinstance(e, tm.NotFoundError)
+    assert e.args == ("lookup 4: user not found",)
+else:
+    raise Exception("Function did not throw the derived error")
+
+# Errors without a directive are not exported
+assert not hasattr(tm, "InternalError")
+try:
+    tm.FunctionReturnCustomError(5)
+except tm.GoError as e:
+    assert type(e) is tm.GoError and str(e) == "internal"
+else:
+    raise Exception("Function did not throw an error")
+
+# ErrQuotaExceeded wraps ErrIO, which is declared first, and is matched before it
+try:
+    tm.FunctionReturnCustomError(6)
+except tm.GoError as e:
+    assert type(e) is tm.QuotaExceededError and not isinstance(e, tm.IOError), type(e)
+    assert str(e) == "write: quota exceeded: i/o error"
+else:
+    raise Exception("Function did not throw an error")
+
+from concurrent.futures import ThreadPoolExecutor
+
+with ThreadPoolExecutor(max_workers=4) as executor:
+    results = list(executor.map(lambda n: tm.FunctionReleasingGIL(n, [1, 2]), [10, 100, 1000, 10000]))
+assert results == [15, 150, 1500, 15000]
+
 v = tm.NewExportedType(1234)
 assert v.GetValue() == 1234
 v.Add(1)
 assert v.GetValue() == 1235
+
+assert v.Value == 1235
+v.Value = 10
+assert v.GetValue() == 10
+try:
+    v.Value = "abc"
+except TypeError as e:
+    assert str(e) == "attribute 'Value': expected int, got str"
+else:
+    raise Exception("Setting attribute did not throw an error")
+
+# Arguments which are pointers to exported types
+assert tm.FunctionSumValues(v, tm.NewExportedType(5)) == 15
+try:
+    tm.FunctionSumValues(v, 5)
+except TypeError as e:
+    assert str(e) == "argument 'b': expected ExportedType, got int", str(e)
+else:
+    raise Exception("Function did not throw an error")
+
+# Float values accept int, but not str
+assert tm.FunctionApply(2, lambda x: x * 1.5) == 3.0
+r = tm.MakeExportedRecord("ints", [1, 2])
+assert r.weights == [1.0, 2.0] and all(type(w) is float for w in r.weights)
+try:
+    tm.MakeExportedRecord("str", ["1"])
+except TypeError as e:
+    assert str(e) == "argument 'weights': expected float, got str", str(e)
+else:
+    raise Exception("Function did not throw an error")
+
+r = tm.NewExportedRecord("abc")
+assert r.name == "abc"
+assert tm.ExportedRecord.name.__doc__ == "Name of the record"
+r.name = "def"
+r.weights = [1.0, 2.5]
+assert r.weights == [1.0, 2.5]
+assert r.count == 0
+r.Increment()
+assert r.count == 1
+try:
+    r.count = 2
+except AttributeError:
+    pass
+else:
+    raise Exception("Setting read-only attribute did not throw an error")
+assert not hasattr(r, "Hidden")
+
+v = tm.ExportedType(1234)
+assert isinstance(v, tm.ExportedType)
+assert v.GetValue() == 1234
+assert tm.ExportedType(v=5).Value == 5
+try:
+    tm.ExportedType("abc")
+except TypeError:
+    pass
+else:
+    raise Exception("Constructor did not throw an error")
+
+r = tm.ExportedRecord("abc", weights=[0.5])
+assert (r.name, r.weights) == ("abc", [0.5])
+assert tm.MakeExportedRecord("def", []).name == "def"
+try:
+    tm.ExportedRecord("", [])
+except tm.GoError as e:
+    assert str(e) == "empty name"
+else:
+    raise Exception("Constructor did not throw an error")
+
+# Wrappers of the same Go pointer are equal, and identical with an identity map
+assert v.Self() == v and not v.Self() != v
+assert v.Self() is not v
+assert hash(v.Self()) == hash(v)
+assert v != tm.ExportedType(v.Value)
+assert len({v, v.Self(), tm.ExportedType(1)}) == 2
+assert r.Self() is r
+
+# Methods with well-known shapes implement the Python special methods
+a, b = tm.Version(1, 2), tm.Version(1, 10)
+assert str(a) == "v1.2" and repr(b) == "v1.10"
+assert a == tm.Version(1, 2) and a != b
+assert hash(a) == hash(tm.Version(1, 2))
+assert a < b and a <= b and b > a and b >= a and a <= tm.Version(1, 2)
+assert sorted([b, a]) == [a, b]
+assert a != v
+
+# Container protocols
+l = tm.NewIntList([1, 2, 3])
+assert len(l) == 3
+assert l[0] == 1 and l[-1] == 3
+l[1] = 20
+l[-1] = 30
+assert list(l) == [1, 20, 30]
+assert 20 in l and 2 not in l
+for i in (3, -4):
+    try:
+        l[i]
+    except IndexError as e:
+        assert str(e) == "IntList index out of range"
+    else:
+        raise Exception("Indexing did not throw an error")
+try:
+    del l[0]
+except TypeError:
+    pass
+else:
+    raise Exception("Deleting an item did not throw an error")
+
+m = tm.NewFloatMap()
+m["a"] = 1.5
+m["b"] = 2
+assert len(m) == 2
+assert m["a"] == 1.5 and m["b"] == 2.0
+assert "a" in m and "c" not in m and 1 not in m
+assert sorted(m) == ["a", "b"]
+del m["a"]
+assert list(m) == ["b"]
+for action in (lambda: m["a"], lambda: m.__delitem__("a")):
+    try:
+        action()
+    except KeyError as e:
+        assert e.args == ("a",)
+    else:
+        raise Exception("Missing key did not throw an error")
+it = iter(m)
+del it
+
+reg = tm.NewRegistry()
+assert reg["abc"] == "registered abc"
+assert reg.Lookup("abc") == "registered abc"
+try:
+    reg[""]
+except KeyError as e:
+    assert isinstance(e, tm.NotFoundError)
+else:
+    raise Exception("Missing key did not throw an error")
+
+# Operators
+v, w = tm.Vector(1, 2), tm.Vector(3, 4)
+assert ((v + w).X, (v + w).Y) == (4, 6)
+assert ((w - v).X, (w - v).Y) == (2, 2)
+assert ((v * 2).X, (2 * v).Y) == (2, 4)
+assert ((-v).X, (v / 2).Y) == (-1, 1)
+for action in (lambda: v + 1, lambda: v * "a", lambda: "a" * v, lambda: 1 - v):
+    try:
+        action()
+    except TypeError:
+        pass
+    else:
+        raise Exception("Unsupported operand did not throw an error")
+try:
+    v / 0
+except tm.GoError as e:
+    assert str(e) == "division by zero"
+else:
+    raise Exception("Operator did not throw an error")
+u = v
+v += w
+assert v is u and (v.X, v.Y) == (4, 6)
+v -= w
+assert v is not u and (v.X, v.Y) == (1, 2)
+# ExportedType.Add modifies the receiver and is not an operator
+e = tm.NewExportedType(10)
+try:
+    e + 5
+except TypeError:
+    pass
+else:
+    raise Exception("Mutating Add method was used as an operator")
+assert e.GetValue() == 10
+assert e.Add(5) == 15
+exported_type_stub = next(node for node in stub.body if isinstance(node, ast.ClassDef) and node.name == "ExportedType")
+assert "__add__" not in {node.name for node in exported_type_stub.body if isinstance(node, ast.FunctionDef)}
+
+# Context managers
+with tm.OpenDB("test") as db:
+    assert db.Query("a") == "test:a"
+try:
+    db.Query("a")
+except ValueError as e:
+    assert str(e) == "operation on closed Database"
+else:
+    raise Exception("Closed object did not throw an error")
+db.Close()
+
+try:
+    with tm.OpenDB("test") as db:
+        raise KeyError("abc")
+except KeyError:
+    pass
+else:
+    raise Exception("Exception was not propagated")
+try:
+    db.IsClosed()
+except ValueError:
+    pass
+else:
+    raise Exception("Closed object did not throw an error")
+
+try:
+    with tm.OpenDB("fail") as db:
+        pass
+except tm.GoError as e:
+    assert str(e) == "close failed"
+else:
+    raise Exception("Close did not throw an error")
+
+assert db.Query("a") == "fail:a", "Object was closed although Close failed"
+
+db = tm.OpenDB("test")
+assert len(db) == 0 and (db / "t").Query("a") == "test/t:a"
+assert str(db) == repr(db) == "Database(test)"
+db.Name = "main"
+db.Close()
+# repr() of closed objects is used by tracebacks and debuggers and does not raise
+assert repr(db) == "<closed Database>"
+for action in (lambda: db.__enter__(), lambda: db.Name, lambda: setattr(db, "Name", "x"), lambda: len(db), lambda: db / "t", lambda: str(db)):
+    try:
+        action()
+    except ValueError as e:
+        assert str(e) == "operation on closed Database", e
+    else:
+        raise Exception("Closed object did not throw an error")
+
+# Iterators
+it = tm.FunctionCount(1000)
+assert tm.FunctionCountState() == [0, 0]
+assert next(it) == 0 and next(it) == 1
+assert tm.FunctionCountState() == [2, 0]
+del it
+assert tm.FunctionCountState() == [2, 1]
+it = tm.FunctionCount(3)
+assert list(it) == [0, 1, 2]
+assert tm.FunctionCountState() == [3, 1]
+try:
+    next(it)
+except StopIteration:
+    pass
+else:
+    raise Exception("Exhausted iterator did not throw StopIteration")
+assert list(tm.FunctionEnumerate(["a", "b"])) == [(0, "a"), (1, "b")]
+assert list(tm.NewIntList([4, 5]).Backward()) == [(1, 5), (0, 4)]
+
+# Channels
+ch = tm.FunctionSendValues(3, 0)
+assert list(ch) == [0, 1, 2]
+assert next(ch, None) is None
+ch = tm.FunctionSendValues(1000, 0)
+assert next(ch) == 0
+ch.close()
+assert list(ch) == []
+for i in range(100):
+    if tm.FunctionSendFinished():
+        break
+    time.sleep(0.01)
+else:
+    raise Exception("Closing the channel did not unblock the Go producer")
+
+# The GIL is released while waiting for the values
+ticks = []
+thread = threading.Thread(target=lambda: [ticks.append(time.sleep(0.01)) for _ in range(5)])
+thread.start()
+assert list(tm.FunctionSendValues(2, 200)) == [0, 1]
+assert len(ticks) == 5
+thread.join()
+
+async def collect_values():
+    ticks = []
+    async def tick():
+        for _ in range(5):
+            await asyncio.sleep(0.01)
+            ticks.append(None)
+    task = asyncio.create_task(tick())
+    values = [v async for v in tm.FunctionSendValues(3, 50)]
+    assert len(ticks) == 5
+    await task
+    return values
+
+assert asyncio.run(collect_values()) == [0, 1, 2]
+
+async def cancel_next():
+    ch = tm.FunctionSendValues(2, 200)
+    try:
+        await asyncio.wait_for(ch.__anext__(), 0.01)
+    except asyncio.TimeoutError:
+        pass
+    else:
+        raise Exception("Waiting for the channel did not time out")
+    ch.close()
+    try:
+        await ch.__anext__()
+    except StopAsyncIteration:
+        pass
+    else:
+        raise Exception("Closed channel did not stop the iteration")
+
+asyncio.run(cancel_next())
+
+async def cancel_next_keeps_value():
+    # The value received for the cancelled future is returned by the next call
+    ch = tm.FunctionSendValues(3, 50)
+    try:
+        await asyncio.wait_for(ch.__anext__(), 0.01)
+    except asyncio.TimeoutError:
+        pass
+    else:
+        raise Exception("Waiting for the channel did not time out")
+    await asyncio.sleep(0.1)
+    return [v async for v in ch]
+
+assert asyncio.run(cancel_next_keeps_value()) == [0, 1, 2]
+try:
+    tm.FunctionSendValues(1, 0).__anext__()
+except RuntimeError:
+    pass
+else:
+    raise Exception("Async iteration without an event loop did not throw an error")
+
+# Asynchronous functions
+async def call_async():
+    start = time.monotonic()
+    assert await asyncio.gather(tm.FunctionSlowSquare(2, 200), tm.FunctionSlowSquare(3, 200)) == [4, 9]
+    assert time.monotonic() - start < 0.35
+    try:
+        await tm.FunctionSlowSquare(-1, 0)
+    except tm.ValidationError as e:
+        assert str(e) == "invalid value -1"
+    else:
+        raise Exception("Function did not throw an error")
+    try:
+        await tm.FunctionAsyncPanics(5)
+    except tm.GoPanicError as e:
+        assert "index out of range" in str(e)
+        assert "FunctionAsyncPanics" in e.go_stack
+    else:
+        raise Exception("Function did not throw an error")
+    future = tm.FunctionSlowSquare(4, 50)
+    assert isinstance(future, asyncio.Future)
+    future.cancel()
+    await asyncio.sleep(0.1)
+    assert future.cancelled()
+
+asyncio.run(call_async())
+try:
+    tm.FunctionSlowSquare(1, 0)
+except RuntimeError:
+    pass
+else:
+    raise Exception("Async function without an event loop did not throw an error")
+
+# Context
+def wait_context_error(expected):
+    for i in range(100):
+        if tm.FunctionLastContextError() == expected:
+            return
+        time.sleep(0.01)
+    raise Exception(f"Context error {tm.FunctionLastContextError()!r} instead of {expected!r}")
+
+assert tm.FunctionWaitContext(10) == 10
+assert tm.FunctionWaitContext(10, timeout=1) == 10
+assert "timeout: float | None = None" in tm.FunctionWaitContext.__doc__
+try:
+    tm.FunctionWaitContext(1000, timeout=0.05)
+except TimeoutError as e:
+    assert str(e) == "context deadline exceeded"
+else:
+    raise Exception("Function did not time out")
+wait_context_error("context deadline exceeded")
+try:
+    tm.FunctionWaitContext(10, timeout="a")
+except TypeError:
+    pass
+else:
+    raise Exception("Invalid timeout did not throw an error")
+
+threading.Timer(0.05, _thread.interrupt_main).start()
+try:
+    tm.FunctionWaitContext(5000)
+except KeyboardInterrupt:
+    pass
+else:
+    raise Exception("Function was not interrupted")
+wait_context_error("context canceled")
+
+async def cancel_context():
+    future = tm.FunctionWaitContextAsync(5000)
+    await asyncio.sleep(0.05)
+    future.cancel()
+    await asyncio.sleep(0)
+    wait_context_error("context canceled")
+    assert await tm.FunctionWaitContextAsync(10, timeout=1) == 10
+
+asyncio.run(cancel_context())
+
+ch = tm.FunctionTicks()
+assert next(ch) == 0 and next(ch) == 1
+ch.close()
+wait_context_error("context canceled")
+
+# Types resolved across the files of the package
+assert tm.NewIntList([1, 2, 3]).Sum() == 6
+assert list(tm.FunctionScores([1.0, 2.5], 2.0)) == [2.0, 5.0]
+assert "values: list[float], factor: float" in tm.FunctionScores.__doc__
+
+# Python callables as Go funcs
+assert tm.FunctionFilter([1, 2, 3, 4], "x", lambda v, label: v % 2 == 0) == [2, 4]
+assert "keep: collections.abc.Callable[[int, str], bool]" in tm.FunctionFilter.__doc__
+labels = []
+tm.FunctionFilter([1], "abc", lambda v, label: labels.append(label) or True)
+assert labels == ["abc"]
+
+def fail(v, label):
+    raise KeyError("fail")
+
+try:
+    tm.FunctionFilter([1, 2], "x", fail)
+except KeyError as e:
+    assert e.args == ("fail",)
+else:
+    raise Exception("Callable exception was not propagated")
+try:
+    tm.FunctionFilter([1, 2], "x", lambda v, label: "a")
+except TypeError:
+    pass
+else:
+    raise Exception("Invalid callable result did not throw an error")
+try:
+    tm.FunctionFilter([1, 2], "x", 42)
+except TypeError:
+    pass
+else:
+    raise Exception("Non-callable argument did not throw an error")
+
+assert tm.FunctionApply(2.0, lambda v: v * 1.5) == 3.0
+assert tm.FunctionApply(2.0, None) == 2.0
+try:
+    tm.FunctionApply(2.0, lambda v: 1 / 0)
+except ZeroDivisionError:
+    pass
+else:
+    raise Exception("Callable exception was not propagated")
+
+# Callables can be called from other goroutines
+assert tm.FunctionApplyConcurrently(20, lambda v: v * v) == sum(v * v for v in range(20))
+
+# Go funcs as Python callables
+scorer = tm.FunctionMakeScorer("go", 0.5)
+assert scorer("gopher") == 3.0 and scorer("python") == 0.0
+assert str(inspect.signature(scorer)) == "(name: 'str', /) -> 'float'"
+assert "Calls the Go func(name string) float64" in scorer.__doc__
+try:
+    scorer(1)
+except TypeError as e:
+    assert "argument 'name'" in str(e)
+else:
+    raise Exception("Invalid argument did not throw an error")
+try:
+    scorer()
+except TypeError:
+    pass
+else:
+    raise Exception("Missing argument did not throw an error")
+try:
+    scorer(name="go")
+except TypeError:
+    pass
+else:
+    raise Exception("Keyword argument did not throw an error")
+
+divide = tm.FunctionMakeDivider()
+assert divide(7, 2) == 3
+assert str(inspect.signature(divide)) == "(a0: 'int', a1: 'int', /) -> 'int'"
+try:
+    divide(1, 0)
+except tm.ValidationError:
+    pass
+else:
+    raise Exception("Go func did not throw an error")
+assert tm.FunctionMakeFunc(False) is None
+assert tm.FunctionMakeFunc(True)() is None
+assert tm.FunctionTwice(lambda v: v * 3)(2) == 18
+
+# Named types are converted through their underlying type
+assert tm.FunctionWarmer(20.5, 1) == 21.5
+assert "FunctionWarmer(c: Celsius, delta: Celsius) -> Celsius" in tm.FunctionWarmer.__doc__
+assert tm.FunctionPrefixTags(["a", "b"], "x-") == ["x-a", "x-b"]
+assert tm.FunctionLabelKeys({"b": "1", "a": "2"}) == ["a", "b"]
+assert tm.FunctionMakeLabels(["a", "b"], "v") == {"a": "v", "b": "v"}
+assert tm.FunctionToggle(True) is False
+assert tm.FunctionDoubleDuration(21) == 42
+try:
+    tm.FunctionPrefixTags([1], "x")
+except TypeError as e:
+    assert "argument 'tags'" in str(e)
+else:
+    raise Exception("Invalid argument did not throw an error")
+
+# Typed constants are exported as enums
+assert issubclass(tm.Mode, enum.IntEnum)
+assert [m.name for m in tm.Mode] == ["Read", "Write", "ReadWrite"]
+assert tm.Mode.__doc__ == "Mode is the access mode of a file."
+assert tm.FunctionNextMode(tm.Mode.Read) is tm.Mode.Write
+assert tm.FunctionNextMode(2) is tm.Mode.Read
+try:
+    tm.FunctionNextMode(5)
+except ValueError as e:
+    assert str(e) == "argument 'm': 5 is not a valid Mode", e
+else:
+    raise Exception("Invalid enum value did not throw an error")
+# Results which are not members are returned as their value
+result = tm.FunctionInvalidMode()
+assert result == 42 and type(result) is int
+# Types with constants are enums only with the directive
+assert tm.FunctionCombineFlags(1, 2) == 3 and not hasattr(tm, "Flags")
+assert tm.Color.DARK_RED == "dark-red"
+assert tm.Color.DEFAULT is tm.Color.BLUE
+counts = tm.FunctionCountColors(["blue", tm.Color.BLUE, tm.Color.DARK_RED])
+assert counts == {tm.Color.BLUE: 2, tm.Color.DARK_RED: 1}
+assert all(type(c) is tm.Color for c in counts)
+try:
+    tm.FunctionCountColors(["red"])
+except ValueError:
+    pass
+else:
+    raise Exception("Invalid enum value did not throw an error")
+
+# Constants and variables as module attributes
+assert tm.MaxItems == 100
+assert tm.DefaultName == "gopher" and tm.DefaultTemperature == 21.5
+assert tm.Greetings == ["hello", "bonjour"]
+assert tm.DefaultMode is tm.Mode.Write
+assert tm.Counter == 0
+tm.FunctionIncrementCounter()
+assert tm.Counter == 1
+assert not hasattr(tm, "Missing")
+assert {"MaxItems", "Counter", "Greetings"} <= {node.target.id for node in stub.body if isinstance(node, ast.AnnAssign)}
+
+# Structs converted by value to and from dataclasses
+assert dataclasses.is_dataclass(tm.Point) and tm.Point.__module__ == "testmodule"
+# Segment.Ref is skipped: its tag "ref-id" is not a valid Python identifier
+assert [f.name for f in dataclasses.fields(tm.Segment)] == ["from_", "to", "label", "Mode", "Tags"]
+assert tm.Origin == tm.Point(0, 0)
+assert tm.FunctionMidpoint(tm.Point(0, 0), tm.Point(2, 4)) == tm.Point(1, 2)
+assert tm.FunctionMidpoint({"X": 1, "Y": 1}, (3, 5)) == tm.Point(2, 3)
+assert tm.FunctionTranslate([tm.Point(1, 2), (3, 4)], 1, -1) == [tm.Point(2, 1), tm.Point(4, 3)]
+s = tm.FunctionMakeSegment(tm.Point(0, 0), tm.Point(3, 4), "diagonal")
+assert s == tm.Segment(tm.Point(0, 0), tm.Point(3, 4), "diagonal", tm.Mode.Write, {"ends": [tm.Point(0, 0), tm.Point(3, 4)]})
+assert s.Mode is tm.Mode.Write
+assert tm.FunctionSegmentLength(s) == 5
+assert tm.FunctionSegmentLength({"from_": (0, 0), "to": {"X": 0, "Y": 2}, "label": "", "Mode": 0, "Tags": {}}) == 2
+try:
+    tm.FunctionMidpoint({"X": 1}, (0, 0))
+except KeyError as e:
+    assert e.args == ("Y",)
+else:
+    raise Exception("Missing field did not throw an error")
+p = tm.Point(1, 2)
+del p.Y
+try:
+    tm.FunctionMidpoint(p, (0, 0))
+except AttributeError:
+    pass
+else:
+    raise Exception("Deleted field did not throw an error")
+for arg in [(1, 2, 3), "point", {"X": "1", "Y": 2}]:
+    try:
+        tm.FunctionMidpoint(arg, (0, 0))
+    except TypeError:
+        pass
+    else:
+        raise Exception("Invalid struct did not throw an error")
+assert {"Point", "Segment"} <= stub_names
+# Fields named after their type, like Segment.Mode, must not shadow the type
+assert isinstance(stub.body[0], ast.ImportFrom) and stub.body[0].module == "__future__"
+
+# The Go objects are released when the Python objects are garbage collected
+del v, r
+for i in range(100000):
+    tm.NewExportedTypes(i, i)
+before = tm.FunctionGoMemStats()
+for i in range(1000000):
+    x, y = tm.NewExportedTypes(i, i)
+    assert y.Value == i
+del x, y
+after = tm.FunctionGoMemStats()
+assert after["handles"] == before["handles"], (before, after)
+assert after["heap"] < before["heap"] + 1024 * 1024, (before, after)
+
+# The Python callables are released once the Go funcs are garbage collected
+class Callback:
+    def __call__(self, v):
+        return v
+callback = Callback()
+callback_ref = weakref.ref(callback)
+assert tm.FunctionApply(1.0, callback) == 1.0
+del callback
+for _ in range(100):
+    tm.FunctionGoMemStats()
+    if callback_ref() is None:
+        break
+    time.sleep(0.01)
+assert callback_ref() is None, "Python callable was not released"
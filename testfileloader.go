@@ -0,0 +1,38 @@
+//go:build python
+
+package main
+
+// The types are resolved by the type checker, across the files of the package
+// and through the import aliases.
+
+import (
+	gocontext "context"
+	stditer "iter"
+)
+
+// Score is an alias of float64.
+type Score = float64
+
+// Sum is a method of a type declared in testfile.go.
+//
+// go:pyexport
+func (l *IntList) Sum() int {
+	sum := 0
+	for _, v := range l.values {
+		sum += v
+	}
+	return sum
+}
+
+// FunctionScores returns the scores of the values, until the context is done.
+//
+// go:pyexport
+func FunctionScores(ctx gocontext.Context, values []Score, factor Score) stditer.Seq[Score] {
+	return func(yield func(Score) bool) {
+		for _, v := range values {
+			if ctx.Err() != nil || !yield(v*factor) {
+				return
+			}
+		}
+	}
+}
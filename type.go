@@ -3,6 +3,11 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/types"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 )
@@ -44,58 +49,83 @@ const (
 	Byte
 	ByteArray
 	NumpyArray
+	Seq
+	Seq2
+	Context
+	Enum
 )
 
 type GoType struct {
 	T             Kind
-	SliceElemType *GoType
-	MapKeyType    *GoType
-	MapValType    *GoType
+	SliceElemType *GoType // Element type of slices, channels and iter.Seq
+	MapKeyType    *GoType // Key type of maps and iter.Seq2
+	MapValType    *GoType // Value type of maps and iter.Seq2
 	PointerTo     *GoType
 	GoRepr        string
+
+	// Named types are converted through their underlying type, e.g. float64 for
+	// type Celsius float64. Named is the name of the named types of the package,
+	// which are declared as type aliases in the Python stub.
+	Underlying string
+	Named      string
+
+	// Parameters and results of func types, without the optional last error
+	FuncParams       []*GoType
+	FuncParamNames   []string // Empty for unnamed parameters
+	FuncResults      []*GoType
+	FuncReturnsError bool
+
+	// Fields of structs converted by value
+	StructFields []*StructField
 }
 
-func ToKind(v string) Kind {
+// StructField is an exported field of a struct converted by value.
+type StructField struct {
+	GoName string
+	PyName string
+	Type   *GoType
+}
+
+func ToKind(v string) (Kind, error) {
 	switch v {
 	case "int":
-		return Int
+		return Int, nil
 	case "int8":
-		return Int8
+		return Int8, nil
 	case "int16":
-		return Int16
-	case "int32":
-		return Int32
+		return Int16, nil
+	case "int32", "rune":
+		return Int32, nil
 	case "int64":
-		return Int64
+		return Int64, nil
 	case "uint":
-		return Uint
+		return Uint, nil
 	case "uint8":
-		return Uint8
+		return Uint8, nil
 	case "uint16":
-		return Uint16
+		return Uint16, nil
 	case "uint32":
-		return Uint32
+		return Uint32, nil
 	case "uint64":
-		return Uint64
+		return Uint64, nil
 	case "bool":
-		return Bool
+		return Bool, nil
 	case "error":
-		return Error
+		return Error, nil
 	case "float32":
-		return Float32
+		return Float32, nil
 	case "float64":
-		return Float64
+		return Float64, nil
 	case "complex64":
-		return Complex64
+		return Complex64, nil
 	case "complex128":
-		return Complex128
+		return Complex128, nil
 	case "string":
-		return String
+		return String, nil
 	case "byte":
-		return Byte
+		return Byte, nil
 	}
-	log.Fatal().Caller().Msgf("Type '%s' not supported!", v)
-	panic("")
+	return Invalid, fmt.Errorf("Type '%s' not supported!", v)
 }
 
 func IsCPyObjectPtr(expr ast.Expr) bool {
@@ -117,91 +147,260 @@ func IsCPyObjectPtr(expr ast.Expr) bool {
 	return false
 }
 
-func IsPkgStruct(expr ast.Expr, pkg, structname string) bool {
-	sta, ok := expr.(*ast.StarExpr)
-	if !ok {
-		return false
+const numpyPkgPath = "github.com/fabgeyer/goserpent/numpy"
+
+// isNamed returns true if t is the named type pkgPath.name.
+func isNamed(t *types.Named, pkgPath, name string) bool {
+	obj := t.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkgPath && obj.Name() == name
+}
+
+// AsGoType returns the GoType of the type expression of the package.
+func AsGoType(expr ast.Expr, pkg *GoPackage) (*GoType, error) {
+	t := pkg.TypeOf(expr)
+	if t == nil && !IsCPyObjectPtr(expr) {
+		return nil, fmt.Errorf("Type '%s' could not be type checked", pkg.Source(expr))
 	}
-	sel, ok := sta.X.(*ast.SelectorExpr)
-	if !ok {
-		return false
+	return asGoType(t, expr, pkg)
+}
+
+// asGoType converts the Go type t. The optional expression expr is the source
+// of the type, which identifies the cgo types faked by the type checker.
+func asGoType(t types.Type, expr ast.Expr, pkg *GoPackage) (*GoType, error) {
+	if expr != nil && IsCPyObjectPtr(expr) {
+		return &GoType{T: CPyObjectPointer}, nil
 	}
-	ide, ok := sel.X.(*ast.Ident)
-	if !ok {
-		return false
+	unsupported := func() (*GoType, error) {
+		return nil, fmt.Errorf("Type '%s' not supported!", pkg.TypeString(t))
 	}
-	if ide.Name == pkg && sel.Sel.Name == structname {
-		return true
+	if t == nil {
+		return unsupported()
 	}
-	return false
-}
+	repr := pkg.TypeString(t)
 
-func AsGoType(expr ast.Expr, context []byte) (*GoType, error) {
-	switch v := expr.(type) {
-	case *ast.Ident:
-		return &GoType{
-			T:      ToKind(v.Name),
-			GoRepr: GetSourceString(context, expr),
-		}, nil
-
-	case *ast.StarExpr:
-		if IsCPyObjectPtr(v) {
-			return &GoType{T: CPyObjectPointer}, nil
-
-		} else if IsPkgStruct(v, "numpy", "Array") {
-			return &GoType{
-				T:      NumpyArray,
-				GoRepr: GetSourceString(context, expr),
-			}, nil
-
-		} else {
-			return &GoType{
-				T:      Pointer,
-				GoRepr: GetSourceString(context, expr)[1:],
-			}, nil
+	switch v := types.Unalias(t).(type) {
+	case *types.Basic:
+		kind, err := ToKind(v.Name())
+		if err != nil {
+			return nil, err
 		}
+		return &GoType{T: kind, GoRepr: repr}, nil
 
-	case *ast.ArrayType:
-		elt, err := AsGoType(v.Elt, context)
+	case *types.Pointer:
+		elem, ok := types.Unalias(v.Elem()).(*types.Named)
+		switch {
+		case !ok:
+		case isNamed(elem, numpyPkgPath, "Array"):
+			if err := pkg.refer(elem); err != nil {
+				return nil, err
+			}
+			return &GoType{T: NumpyArray, GoRepr: repr}, nil
+		case elem.Obj().Pkg() == pkg.Types:
+			return &GoType{T: Pointer, GoRepr: pkg.TypeString(elem)}, nil
+		}
+
+	case *types.Slice:
+		var eltExpr ast.Expr
+		if a, ok := expr.(*ast.ArrayType); ok {
+			eltExpr = a.Elt
+		}
+		elt, err := asGoType(v.Elem(), eltExpr, pkg)
 		if err != nil {
 			return nil, err
 		}
-
-		switch elt.T {
-		case Byte:
+		if elt.T == Byte && elt.Underlying == "" {
 			// Note: Handle byte slices as its own type as Python has its own PyBytes
-			return &GoType{
-				T:      ByteArray,
-				GoRepr: GetSourceString(context, expr),
-			}, nil
+			return &GoType{T: ByteArray, GoRepr: repr}, nil
+		}
+		return &GoType{T: Slice, SliceElemType: elt, GoRepr: repr}, nil
 
+	case *types.Map:
+		var keyExpr, valExpr ast.Expr
+		if m, ok := expr.(*ast.MapType); ok {
+			keyExpr, valExpr = m.Key, m.Value
+		}
+		mapKeyType, err := asGoType(v.Key(), keyExpr, pkg)
+		if err != nil {
+			return nil, err
+		}
+		mapValType, err := asGoType(v.Elem(), valExpr, pkg)
+		if err != nil {
+			return nil, err
+		}
+		return &GoType{T: Map, MapKeyType: mapKeyType, MapValType: mapValType, GoRepr: repr}, nil
+
+	case *types.Chan:
+		// Only receive-only channels can be returned to Python
+		if v.Dir() == types.RecvOnly {
+			var eltExpr ast.Expr
+			if c, ok := expr.(*ast.ChanType); ok {
+				eltExpr = c.Value
+			}
+			elt, err := asGoType(v.Elem(), eltExpr, pkg)
+			if err != nil {
+				return nil, err
+			}
+			return &GoType{T: Chan, SliceElemType: elt, GoRepr: repr}, nil
+		}
+
+	case *types.Signature:
+		return asGoFuncType(v, repr, pkg)
+
+	case *types.Named:
+		if err := pkg.refer(v); err != nil {
+			return nil, err
+		}
+		switch {
+		case v.Obj().Pkg() == nil && v.Obj().Name() == "error":
+			return &GoType{T: Error, GoRepr: repr}, nil
+		case isNamed(v, "context", "Context"):
+			return &GoType{T: Context, GoRepr: repr}, nil
+		case isNamed(v, "iter", "Seq") && v.TypeArgs().Len() == 1:
+			elt, err := asGoType(v.TypeArgs().At(0), nil, pkg)
+			if err != nil {
+				return nil, err
+			}
+			return &GoType{T: Seq, SliceElemType: elt, GoRepr: repr}, nil
+		case isNamed(v, "iter", "Seq2") && v.TypeArgs().Len() == 2:
+			keyType, err := asGoType(v.TypeArgs().At(0), nil, pkg)
+			if err != nil {
+				return nil, err
+			}
+			valType, err := asGoType(v.TypeArgs().At(1), nil, pkg)
+			if err != nil {
+				return nil, err
+			}
+			return &GoType{T: Seq2, MapKeyType: keyType, MapValType: valType, GoRepr: repr}, nil
 		default:
-			return &GoType{
-				T:             Slice,
-				SliceElemType: elt,
-				GoRepr:        GetSourceString(context, expr),
-			}, nil
+			return asGoNamedType(v, repr, pkg)
 		}
+	}
+	return unsupported()
+}
 
-	case *ast.MapType:
-		mapKeyType, err := AsGoType(v.Key, context)
+// hasValueUnderlying returns true if the named type can be converted through
+// its underlying type, or field by field for structs.
+func hasValueUnderlying(named types.Type) bool {
+	switch named.Underlying().(type) {
+	case *types.Basic, *types.Slice, *types.Map, *types.Signature, *types.Struct:
+		return true
+	}
+	return false
+}
+
+// asGoNamedType converts the named type through its underlying type, while the
+// generated code keeps the name of the type.
+func asGoNamedType(named *types.Named, repr string, pkg *GoPackage) (*GoType, error) {
+	if !hasValueUnderlying(named) {
+		return nil, fmt.Errorf("Type '%s' not supported!", repr)
+	}
+	if st, ok := named.Underlying().(*types.Struct); ok {
+		return asGoStructType(named, st, repr, pkg)
+	}
+	g, err := asGoType(named.Underlying(), nil, pkg)
+	if err != nil {
+		return nil, err
+	}
+	g.Underlying = g.GoRepr
+	g.GoRepr = repr
+	if named.Obj().Pkg() == pkg.Types && named.TypeArgs().Len() == 0 {
+		g.Named = named.Obj().Name()
+	}
+	if len(pkg.EnumConsts(named)) > 0 {
+		g.T = Enum
+	}
+	return g, nil
+}
+
+// asGoFuncType converts the Go func type, which returns at most one value and
+// optionally an error.
+func asGoFuncType(sig *types.Signature, repr string, pkg *GoPackage) (*GoType, error) {
+	if sig.Variadic() {
+		return nil, fmt.Errorf("Type '%s' not supported: variadic functions", repr)
+	}
+	g := &GoType{T: Func, GoRepr: repr}
+	for i := range sig.Params().Len() {
+		param := sig.Params().At(i)
+		pt, err := asGoType(param.Type(), nil, pkg)
 		if err != nil {
 			return nil, err
 		}
-		mapValType, err := AsGoType(v.Value, context)
+		g.FuncParams = append(g.FuncParams, pt)
+		g.FuncParamNames = append(g.FuncParamNames, strings.TrimPrefix(param.Name(), "_"))
+	}
+	n := sig.Results().Len()
+	if n > 0 && types.Identical(sig.Results().At(n-1).Type(), types.Universe.Lookup("error").Type()) {
+		g.FuncReturnsError = true
+		n--
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("Type '%s' not supported: functions return a single value and optionally an error", repr)
+	}
+	for i := range n {
+		rt, err := asGoType(sig.Results().At(i).Type(), nil, pkg)
 		if err != nil {
 			return nil, err
 		}
-		return &GoType{
-			T:          Map,
-			MapKeyType: mapKeyType,
-			MapValType: mapValType,
-			GoRepr:     GetSourceString(context, expr),
-		}, nil
+		g.FuncResults = append(g.FuncResults, rt)
+	}
+	return g, nil
+}
 
-	default:
-		return nil, fmt.Errorf("Type '%s' (%T) not supported!", GetSourceString(context, expr), expr)
+// asGoStructType converts the struct by value, field by field. The fields are
+// renamed in Python with the pyexport, py or json tags, and skipped with "-".
+func asGoStructType(named *types.Named, st *types.Struct, repr string, pkg *GoPackage) (*GoType, error) {
+	if !pkg.IsValueType(named) || named.TypeArgs().Len() > 0 {
+		return nil, fmt.Errorf("Type '%s' not supported!", repr)
+	}
+	if g, ok := pkg.structs[repr]; ok {
+		return g, nil
+	}
+	g := &GoType{T: Struct, GoRepr: repr}
+	pkg.structs[repr] = g
+
+	for i := range st.NumFields() {
+		field := st.Field(i)
+		if !field.Exported() || field.Embedded() {
+			continue
+		}
+		pyName := ""
+		tag := reflect.StructTag(st.Tag(i))
+		for _, key := range []string{"pyexport", "py", "json"} {
+			if v, ok := tag.Lookup(key); ok {
+				pyName, _, _ = strings.Cut(v, ",")
+				break
+			}
+		}
+		if pyName == "-" {
+			continue
+		}
+		if pyName == "" {
+			pyName = field.Name()
+			if args.UseSnakeCase {
+				pyName = ToSnakeCase(pyName)
+			}
+		}
+
+		ft, err := asGoType(field.Type(), nil, pkg)
+		if err == nil && (!ft.IsSettable() || ft.T == Pointer) {
+			err = fmt.Errorf("Type '%s' not supported!", pkg.TypeString(field.Type()))
+		}
+		if err == nil {
+			var ok bool
+			if pyName, ok = PyIdentifier(pyName); !ok {
+				err = fmt.Errorf("Invalid Python field name '%s'", pyName)
+			}
+		}
+		if err != nil {
+			log.Warn().
+				Str("field", repr+"."+field.Name()).
+				Err(err).
+				Msg("Skip struct field")
+			continue
+		}
+		g.StructFields = append(g.StructFields, &StructField{GoName: field.Name(), PyName: pyName, Type: ft})
 	}
+	return g, nil
 }
 
 func (g *GoType) Unsupported() {
@@ -239,7 +438,7 @@ func (g *GoType) PyArgFormat() string {
 		return "D"
 	case String:
 		return "s"
-	case Map, Slice, CPyObjectPointer, NumpyArray:
+	case Map, Slice, CPyObjectPointer, NumpyArray, Pointer, Func, Enum, Struct:
 		return "O"
 	}
 	g.Unsupported()
@@ -276,7 +475,7 @@ func (g *GoType) GoCType() string {
 		return "C.Py_complex"
 	case String:
 		return "*C.char"
-	case Map, Slice, CPyObjectPointer, NumpyArray:
+	case Map, Slice, CPyObjectPointer, NumpyArray, Pointer, Func, Enum, Struct:
 		return "*C.PyObject"
 	}
 	g.Unsupported()
@@ -305,7 +504,7 @@ func (g *GoType) CPtrType() string {
 		return "Py_complex *"
 	case String:
 		return "char **"
-	case Map, Slice, CPyObjectPointer, NumpyArray:
+	case Map, Slice, CPyObjectPointer, NumpyArray, Pointer, Func, Enum, Struct:
 		return "PyObject **"
 	}
 	g.Unsupported()
@@ -313,9 +512,12 @@ func (g *GoType) CPtrType() string {
 }
 
 func (g *GoType) PythonTypeHint() string {
+	if g.Named != "" {
+		return g.Named
+	}
 	switch g.T {
 	case None:
-		return "NoneType"
+		return "None"
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
 		return "int"
 	case String:
@@ -323,48 +525,129 @@ func (g *GoType) PythonTypeHint() string {
 	case Bool:
 		return "bool"
 	case Map:
-		return fmt.Sprintf("Dict[%s, %s]", g.MapKeyType.PythonTypeHint(), g.MapValType.PythonTypeHint())
+		return fmt.Sprintf("dict[%s, %s]", g.MapKeyType.PythonTypeHint(), g.MapValType.PythonTypeHint())
 	case CPyObjectPointer:
 		return "object"
-	case Pointer:
+	case Pointer, Struct:
 		return g.GoRepr
 	case Float32, Float64:
 		return "float"
 	case Complex64, Complex128:
 		return "complex"
 	case Slice:
-		return fmt.Sprintf("List[%s]", g.SliceElemType.PythonTypeHint())
+		return fmt.Sprintf("list[%s]", g.SliceElemType.PythonTypeHint())
 	case ByteArray:
 		return "bytes"
 	case NumpyArray:
-		return "np.ndarray"
+		return "numpy.typing.NDArray[Any]"
+	case Seq:
+		return fmt.Sprintf("collections.abc.Iterator[%s]", g.SliceElemType.PythonTypeHint())
+	case Seq2:
+		return fmt.Sprintf("collections.abc.Iterator[tuple[%s, %s]]", g.MapKeyType.PythonTypeHint(), g.MapValType.PythonTypeHint())
+	case Chan:
+		return fmt.Sprintf("GoChannel[%s]", g.SliceElemType.PythonTypeHint())
+	case Func:
+		var params []string
+		for _, p := range g.FuncParams {
+			params = append(params, p.PythonTypeHint())
+		}
+		result := "None"
+		if len(g.FuncResults) == 1 {
+			result = g.FuncResults[0].PythonTypeHint()
+		}
+		return fmt.Sprintf("collections.abc.Callable[[%s], %s]", strings.Join(params, ", "), result)
 	default:
 		g.Unsupported()
 	}
 	panic("")
 }
 
+// UnderlyingTypeHint returns the Python type hint of the underlying type of
+// named types.
+func (g *GoType) UnderlyingTypeHint() string {
+	u := *g
+	u.Named = ""
+	return u.PythonTypeHint()
+}
+
+// NamedTypes returns the named types of the package used by the type, except
+// the enums which are exported as Python classes.
+func (g *GoType) NamedTypes() []*GoType {
+	var res []*GoType
+	if g.Named != "" && g.T != Enum {
+		res = append(res, g)
+	}
+	for _, t := range g.innerTypes() {
+		res = append(res, t.NamedTypes()...)
+	}
+	return res
+}
+
+// Structs returns the structs converted by value used by the type, without
+// the structs used by their fields.
+func (g *GoType) Structs() []*GoType {
+	var res []*GoType
+	if g.T == Struct {
+		res = append(res, g)
+	}
+	for _, t := range g.innerTypes() {
+		res = append(res, t.Structs()...)
+	}
+	return res
+}
+
+// innerTypes returns the types of the elements, keys and values, or of the
+// parameters and results of func types.
+func (g *GoType) innerTypes() []*GoType {
+	switch g.T {
+	case Slice, Seq, Chan:
+		return []*GoType{g.SliceElemType}
+	case Map, Seq2:
+		return []*GoType{g.MapKeyType, g.MapValType}
+	case Func:
+		return slices.Concat(g.FuncParams, g.FuncResults)
+	}
+	return nil
+}
+
+// asUnderlying converts the value of a named type to its underlying type, for
+// the conversion functions which are not generic.
+func (g *GoType) asUnderlying(varname string) string {
+	if g.Underlying == "" {
+		return varname
+	}
+	return fmt.Sprintf("%s(%s)", g.Underlying, varname)
+}
+
+// asNamed converts the value of the underlying type to the named type.
+func (g *GoType) asNamed(expr string) string {
+	if g.Underlying == "" {
+		return expr
+	}
+	return fmt.Sprintf("%s(%s)", g.GoRepr, expr)
+}
+
 func (g *GoType) GoPyReturn(varname string) string {
 	switch g.T {
 	case None: // Equivalent of Python's None
-		return "return C.Py_None"
+		return "return C.PyIncRef(C.Py_None)"
 	case CPyObjectPointer:
 		return fmt.Sprintf("return %s", varname)
 	case Bool:
-		return fmt.Sprintf("return asPyBool(%s)", varname)
+		return fmt.Sprintf("return asPyBool(%s)", g.asUnderlying(varname))
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
 		return fmt.Sprintf("return asPyLong(%s)", varname)
 	case Float32, Float64:
 		return fmt.Sprintf("return asPyFloat(%s)", varname)
 	case Complex64:
-		return fmt.Sprintf("return goComplex64AsPyComplex(%s)", varname)
+		return fmt.Sprintf("return goComplex64AsPyComplex(%s)", g.asUnderlying(varname))
 	case Complex128:
-		return fmt.Sprintf("return goComplex128AsPyComplex(%s)", varname)
+		return fmt.Sprintf("return goComplex128AsPyComplex(%s)", g.asUnderlying(varname))
 	case String:
-		return fmt.Sprintf("return asPyString(%s)", varname)
+		return fmt.Sprintf("return asPyString(%s)", g.asUnderlying(varname))
 	case Error:
 		return fmt.Sprintf("return asPyError(%s)", varname)
-	case Pointer:
+	case Pointer, Enum, Struct:
 		return fmt.Sprintf("return %sToPyObject(%s)", g.GoRepr, varname)
 	case Map:
 		return fmt.Sprintf("return asPyDict(%s, %s, %s)", varname,
@@ -376,18 +659,48 @@ func (g *GoType) GoPyReturn(varname string) string {
 		return fmt.Sprintf("return asPyBytes(%s)", varname)
 	case NumpyArray:
 		return fmt.Sprintf("return (*C.PyObject)(%s.PyObject())", varname)
+	case Seq, Seq2, Chan:
+		return g.GoPyStreamReturn(varname, "")
+	case Func:
+		return fmt.Sprintf("if %s == nil {\nreturn C.PyIncRef(C.Py_None)\n}\nreturn newPyFunc(%s, %s)",
+			varname, g.PyFuncSignature(), g.GoFuncCalledFromPython(varname))
 	default:
 		g.Unsupported()
 	}
 	panic("")
 }
 
+// GoPyStreamReturn returns the Python iterator over the Go sequence or channel.
+// The optional release function is called once the Python object is released.
+func (g *GoType) GoPyStreamReturn(varname, release string) string {
+	if release != "" {
+		release = ", " + release
+	}
+	switch g.T {
+	case Seq:
+		return fmt.Sprintf("return newPyIterator(%s, %s%s)", varname, g.SliceElemType.GoPyReturnLambda(), release)
+	case Seq2:
+		return fmt.Sprintf("return newPyIterator2(%s, %s, %s%s)", varname,
+			g.MapKeyType.GoPyReturnLambda(),
+			g.MapValType.GoPyReturnLambda(), release)
+	case Chan:
+		return fmt.Sprintf("return newPyChannel(%s, %s%s)", varname, g.SliceElemType.GoPyReturnLambda(), release)
+	}
+	g.Unsupported()
+	panic("")
+}
+
 func (g *GoType) GoPyReturnLambda() string {
+	if g.Underlying != "" && (g.T == Bool || g.T == String) {
+		return fmt.Sprintf("func(v %s) *C.PyObject { %s }", g.GoRepr, g.GoPyReturn("v"))
+	}
 	switch g.T {
 	case CPyObjectPointer:
 		return "identity"
 	case Pointer:
 		return fmt.Sprintf("func(v *%s) *C.PyObject { %s }", g.GoRepr, g.GoPyReturn("v"))
+	case Enum, Struct:
+		return g.GoRepr + "ToPyObject"
 	case Bool:
 		return "asPyBool"
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
@@ -406,29 +719,79 @@ func (g *GoType) CToGoFunction(varname string) string {
 	case CPyObjectPointer:
 		return varname
 	case Bool:
-		return fmt.Sprintf("asGoBool(%s)", varname)
+		return g.asNamed(fmt.Sprintf("asGoBool(%s)", varname))
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Float32, Float64:
 		return fmt.Sprintf("%s(%s)", g.GoRepr, varname)
 	case Complex64:
-		return fmt.Sprintf("asGoComplex64(%s)", varname)
+		return g.asNamed(fmt.Sprintf("asGoComplex64(%s)", varname))
 	case Complex128:
-		return fmt.Sprintf("asGoComplex128(%s)", varname)
+		return g.asNamed(fmt.Sprintf("asGoComplex128(%s)", varname))
 	case String:
-		return fmt.Sprintf("C.GoString(%s)", varname)
+		return g.asNamed(fmt.Sprintf("C.GoString(%s)", varname))
 	case Slice:
-		return fmt.Sprintf("asGoSlice(%s, %s)", varname,
-			g.SliceElemType.CPyObjectToGoLambda())
+		return g.asNamed(fmt.Sprintf("asGoSlice(%s, %s)", varname,
+			g.SliceElemType.CPyObjectToGoLambda()))
 	case Map:
-		return fmt.Sprintf("asGoMap(%s, %s, %s)", varname,
+		return g.asNamed(fmt.Sprintf("asGoMap(%s, %s, %s)", varname,
 			g.MapKeyType.CPyObjectToGoLambda(),
-			g.MapValType.CPyObjectToGoLambda())
+			g.MapValType.CPyObjectToGoLambda()))
 	case NumpyArray:
 		return fmt.Sprintf("asGoNumpyArray(%s)", varname)
+	case Pointer, Enum, Struct:
+		return fmt.Sprintf("%sFromPyObject(%s)", g.GoRepr, varname)
+	case Func:
+		return g.CPyObjectToGo(varname)
 	}
 	g.Unsupported()
 	panic("")
 }
 
+// RefersToTypes returns false if the type refers to a pointer type which is
+// not in types.
+func (g *GoType) RefersToTypes(types map[string]bool) bool {
+	switch g.T {
+	case Pointer:
+		return types[g.GoRepr]
+	case Slice, Seq, Chan:
+		return g.SliceElemType.RefersToTypes(types)
+	case Map, Seq2:
+		return g.MapKeyType.RefersToTypes(types) && g.MapValType.RefersToTypes(types)
+	case Func:
+		for _, t := range slices.Concat(g.FuncParams, g.FuncResults) {
+			if !t.RefersToTypes(types) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GoTypeName returns the Go type, e.g. for variable declarations.
+func (g *GoType) GoTypeName() string {
+	switch g.T {
+	case CPyObjectPointer:
+		return "*C.PyObject"
+	case Pointer:
+		return "*" + g.GoRepr
+	}
+	return g.GoRepr
+}
+
+// IsSettable returns true if the Python objects can be converted to the Go
+// type with CPyObjectToGo.
+func (g *GoType) IsSettable() bool {
+	switch g.T {
+	case Bool, String, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Float32, Float64,
+		Complex64, Complex128, ByteArray, Pointer, Enum, Struct:
+		return true
+	case Slice:
+		return g.SliceElemType.IsSettable()
+	case Map:
+		return g.MapKeyType.IsSettable() && g.MapValType.IsSettable()
+	}
+	return false
+}
+
 func (g *GoType) CToGoLambdaFunction() string {
 	return fmt.Sprintf("func(o %s) %s { return %s }", g.GoCType(), g.GoRepr, g.CToGoFunction("o"))
 }
@@ -436,30 +799,249 @@ func (g *GoType) CToGoLambdaFunction() string {
 func (g *GoType) CPyObjectToGo(cPyObjectVarName string) string {
 	switch g.T {
 	case String:
-		return fmt.Sprintf("pyObjectAsGoString(%s)", cPyObjectVarName)
+		return g.asNamed(fmt.Sprintf("pyObjectAsGoString(%s)", cPyObjectVarName))
+	case Bool:
+		return g.asNamed(fmt.Sprintf("pyObjectAsGoBool(%s)", cPyObjectVarName))
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
 		return fmt.Sprintf("asGoInt[%s](%s)", g.GoRepr, cPyObjectVarName)
 	case Float32, Float64:
 		return fmt.Sprintf("asGoFloat[%s](%s)", g.GoRepr, cPyObjectVarName)
+	case Complex64, Complex128:
+		return fmt.Sprintf("pyObjectAsGoComplex[%s](%s)", g.GoRepr, cPyObjectVarName)
+	case ByteArray:
+		return g.asNamed(fmt.Sprintf("pyObjectAsGoBytes(%s)", cPyObjectVarName))
+	case Slice:
+		return g.asNamed(fmt.Sprintf("asGoSlice(%s, %s)", cPyObjectVarName, g.SliceElemType.CPyObjectToGoLambda()))
+	case Map:
+		return g.asNamed(fmt.Sprintf("asGoMap(%s, %s, %s)", cPyObjectVarName,
+			g.MapKeyType.CPyObjectToGoLambda(),
+			g.MapValType.CPyObjectToGoLambda()))
+	case Pointer, Enum, Struct:
+		return fmt.Sprintf("%sFromPyObject(%s)", g.GoRepr, cPyObjectVarName)
+	case Func:
+		return fmt.Sprintf("asGoFunc(%s, func(_callable *pyCallable) %s {\nreturn %s\n})", cPyObjectVarName, g.GoRepr, g.GoFuncCallingPython("_callable"))
 	}
 	g.Unsupported()
 	panic("")
 }
 
+// GoFuncCallingPython returns the Go closure of the func type, which calls the
+// Python callable with the GIL held. A Python exception is returned as an
+// error, or raised as a panic if the func type does not return an error.
+func (g *GoType) GoFuncCallingPython(callable string) string {
+	var params, args []string
+	for i, p := range g.FuncParams {
+		params = append(params, fmt.Sprintf("a%d %s", i, p.GoTypeName()))
+		args = append(args, fmt.Sprintf("pyArg(a%d, %s)", i, p.GoPyReturnLambda()))
+	}
+	var results []string
+	convert := "nil"
+	if len(g.FuncResults) == 1 {
+		results = append(results, "r0 "+g.FuncResults[0].GoTypeName())
+		convert = fmt.Sprintf("func(o *C.PyObject) {\nr0 = %s\n}", g.FuncResults[0].CPyObjectToGo("o"))
+	}
+	if g.FuncReturnsError {
+		results = append(results, "err error")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "func(%s) (%s) {\n", strings.Join(params, ", "), strings.Join(results, ", "))
+	if g.FuncReturnsError {
+		fmt.Fprintf(&b, "err = %s.call([]func() *C.PyObject{%s}, %s)\n", callable, strings.Join(args, ", "), convert)
+	} else {
+		fmt.Fprintf(&b, "if err := %s.call([]func() *C.PyObject{%s}, %s); err != nil {\npanic(err)\n}\n", callable, strings.Join(args, ", "), convert)
+	}
+	b.WriteString("return\n}")
+	return b.String()
+}
+
+// PyFuncParamNames returns the names of the parameters of the func type in
+// Python. The unnamed parameters are named after their position.
+func (g *GoType) PyFuncParamNames() []string {
+	names := make([]string, len(g.FuncParams))
+	for i, name := range g.FuncParamNames {
+		switch {
+		case name == "":
+			names[i] = fmt.Sprintf("a%d", i)
+		case args.UseSnakeCase:
+			names[i] = ToSnakeCase(name)
+		default:
+			names[i] = name
+		}
+	}
+	return names
+}
+
+// PyFuncSignature returns the pyFuncSignature describing the func type to
+// Python.
+func (g *GoType) PyFuncSignature() string {
+	var hints []string
+	for _, p := range g.FuncParams {
+		hints = append(hints, strconv.Quote(p.PythonTypeHint()))
+	}
+	result := "None"
+	if len(g.FuncResults) == 1 {
+		result = g.FuncResults[0].PythonTypeHint()
+	}
+	var names []string
+	for _, name := range g.PyFuncParamNames() {
+		names = append(names, strconv.Quote(name))
+	}
+	return fmt.Sprintf("pyFuncSignature{goType: %q, params: []string{%s}, hints: []string{%s}, result: %q}",
+		g.GoRepr, strings.Join(names, ", "), strings.Join(hints, ", "), result)
+}
+
+// GoFuncCalledFromPython returns the function calling the Go func fn with the
+// arguments of a Python call, and converting its result to Python.
+func (g *GoType) GoFuncCalledFromPython(fn string) string {
+	var args []string
+	for i, name := range g.PyFuncParamNames() {
+		p := g.FuncParams[i]
+		args = append(args, fmt.Sprintf("convertArg(%q, func() %s { return %s })", name, p.GoTypeName(), p.CPyObjectToGo(fmt.Sprintf("_args[%d]", i))))
+	}
+	call := fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", "))
+
+	var b strings.Builder
+	b.WriteString("func(_args []*C.PyObject) *C.PyObject {\n")
+	switch {
+	case len(g.FuncResults) == 1 && g.FuncReturnsError:
+		fmt.Fprintf(&b, "r0, err := %s\nif err != nil {\nsetPyError(err)\nreturn nil\n}\n", call)
+	case len(g.FuncResults) == 1:
+		fmt.Fprintf(&b, "r0 := %s\n", call)
+	case g.FuncReturnsError:
+		fmt.Fprintf(&b, "if err := %s; err != nil {\nsetPyError(err)\nreturn nil\n}\n", call)
+	default:
+		b.WriteString(call + "\n")
+	}
+	if len(g.FuncResults) == 1 {
+		b.WriteString(g.FuncResults[0].GoPyReturn("r0"))
+	} else {
+		b.WriteString("return C.PyIncRef(C.Py_None)")
+	}
+	b.WriteString("\n}")
+	return b.String()
+}
+
+// CheckFunc returns an error if the func types used by the type cannot be
+// converted. Python callables are converted to Go funcs if fromPython is true,
+// and Go funcs to Python callables otherwise. The values passed from Python to
+// Go, i.e. the results of the callables and the arguments of the Go funcs, need
+// to be convertible from Python objects.
+func (g *GoType) CheckFunc(fromPython bool) error {
+	switch g.T {
+	case Slice, Seq, Chan:
+		return g.SliceElemType.CheckFunc(fromPython)
+	case Map, Seq2:
+		if err := g.MapKeyType.CheckFunc(fromPython); err != nil {
+			return err
+		}
+		return g.MapValType.CheckFunc(fromPython)
+	case Func:
+	default:
+		return nil
+	}
+
+	for _, p := range g.FuncParams {
+		if !fromPython && !p.IsSettable() && p.T != Func {
+			return fmt.Errorf("Type '%s' not supported as parameter of '%s'", p.GoRepr, g.GoRepr)
+		}
+		if err := p.CheckFunc(!fromPython); err != nil {
+			return err
+		}
+	}
+	for _, r := range g.FuncResults {
+		if fromPython && !r.IsSettable() && r.T != Func {
+			return fmt.Errorf("Type '%s' not supported as result of '%s'", r.GoRepr, g.GoRepr)
+		}
+		if err := r.CheckFunc(fromPython); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsesFunc reports if the type uses Python callables converted to Go funcs,
+// and Go funcs converted to Python callables. The funcs are converted from
+// Python if fromPython is true.
+func (g *GoType) UsesFunc(fromPython bool) (callables, goFuncs bool) {
+	var types []*GoType
+	switch g.T {
+	case Slice, Seq, Chan:
+		types = []*GoType{g.SliceElemType}
+	case Map, Seq2:
+		types = []*GoType{g.MapKeyType, g.MapValType}
+	case Func:
+		callables, goFuncs = fromPython, !fromPython
+		for _, p := range g.FuncParams {
+			c, f := p.UsesFunc(!fromPython)
+			callables, goFuncs = callables || c, goFuncs || f
+		}
+		types = g.FuncResults
+	}
+	for _, t := range types {
+		c, f := t.UsesFunc(fromPython)
+		callables, goFuncs = callables || c, goFuncs || f
+	}
+	return
+}
+
 func (g *GoType) CPyObjectToGoLambda() string {
+	if g.Underlying != "" && (g.T == String || g.T == Bool) {
+		return fmt.Sprintf("func(o *C.PyObject) %s { return %s }", g.GoTypeName(), g.CPyObjectToGo("o"))
+	}
 	switch g.T {
 	case String:
 		return "pyObjectAsGoString"
+	case Bool:
+		return "pyObjectAsGoBool"
 	case Float32, Float64:
 		return fmt.Sprintf("asGoFloat[%s]", g.GoRepr)
 	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64:
 		return fmt.Sprintf("asGoInt[%s]", g.GoRepr)
+	case Pointer, Enum, Struct:
+		return fmt.Sprintf("%sFromPyObject", g.GoRepr)
+	case Complex64, Complex128, ByteArray, Slice, Map, Func:
+		return fmt.Sprintf("func(o *C.PyObject) %s { return %s }", g.GoTypeName(), g.CPyObjectToGo("o"))
 	default:
 		g.Unsupported()
 	}
 	panic("")
 }
 
+// EnumValueType returns the type of the values of the enum, converted to and
+// from the raw Python values.
+func (g *GoType) EnumValueType() *GoType {
+	v := *g
+	v.T, _ = ToKind(g.Underlying)
+	return &v
+}
+
 func (g *GoType) IsNotNone() bool {
 	return g.T != None
 }
+
+func (g *GoType) IsInteger() bool {
+	switch g.T {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr:
+		return true
+	}
+	return false
+}
+
+// Uses returns true if the Go type is, or contains, one of the kinds.
+func (g *GoType) Uses(kinds ...Kind) bool {
+	if slices.Contains(kinds, g.T) {
+		return true
+	}
+	switch g.T {
+	case Slice, Seq, Chan:
+		return g.SliceElemType.Uses(kinds...)
+	case Map, Seq2:
+		return g.MapKeyType.Uses(kinds...) || g.MapValType.Uses(kinds...)
+	case Func:
+		return slices.ContainsFunc(slices.Concat(g.FuncParams, g.FuncResults), func(t *GoType) bool {
+			return t.Uses(kinds...)
+		})
+	}
+	return false
+}
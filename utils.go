@@ -2,6 +2,7 @@ package main
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -14,6 +15,27 @@ func ToSnakeCase(str string) string {
 	return strings.ToLower(snake)
 }
 
+var pyKeywords = []string{
+	"False", "None", "True", "and", "as", "assert", "async", "await", "break",
+	"class", "continue", "def", "del", "elif", "else", "except", "finally",
+	"for", "from", "global", "if", "import", "in", "is", "lambda", "nonlocal",
+	"not", "or", "pass", "raise", "return", "try", "while", "with", "yield",
+}
+
+var matchPyIdentifier = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// PyIdentifier returns the name with a trailing underscore if it is a Python
+// keyword, and false if it is not a valid Python identifier.
+func PyIdentifier(name string) (string, bool) {
+	if !matchPyIdentifier.MatchString(name) {
+		return name, false
+	}
+	if slices.Contains(pyKeywords, name) {
+		return name + "_", true
+	}
+	return name, true
+}
+
 func RemoveEmptyLines(src []byte) ([]byte, error) {
 	re := regexp.MustCompile(`(\r?\n|\r){3,}`)
 	return re.ReplaceAll(src, []byte("\n\n")), nil
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type WheelCommand struct {
+	BuildCommand
+	DistName string   `long:"name" description:"Distribution name of the wheel (default: name of the python module)"`
+	Version  string   `long:"version" description:"Version of the wheel" default:"0.1.0"`
+	Summary  string   `long:"summary" description:"One-line summary of the wheel"`
+	Requires []string `long:"requires" description:"Additional requirement of the wheel"`
+	WheelDir string   `long:"wheel-dir" description:"Output directory of the wheel" default:"."`
+	Python   string   `long:"python" description:"Python interpreter for which the wheel is built" default:"python3"`
+}
+
+var wheelCommand WheelCommand
+
+// PythonConfig holds the configuration of the Python interpreter for which the
+// wheel is built.
+type PythonConfig struct {
+	ExtSuffix string `json:"ext_suffix"`
+	Tag       string `json:"tag"`
+	Version   []int  `json:"version"`
+}
+
+// requiresPython is the minimum Python version of the generated modules, which
+// use PyModule_AddObjectRef.
+const requiresPython = ">=3.10"
+
+const pythonConfigScript = `
+import json, sys, sysconfig
+interpreter = "cp%d%d" % sys.version_info[:2]
+platform = sysconfig.get_platform().replace("-", "_").replace(".", "_")
+print(json.dumps({
+	"ext_suffix": sysconfig.get_config_var("EXT_SUFFIX"),
+	"tag": "%s-%s%s-%s" % (interpreter, interpreter, sys.abiflags, platform),
+	"version": sys.version_info[:2],
+}))
+`
+
+func GetPythonConfig(python string) (*PythonConfig, error) {
+	out, err := exec.Command(python, "-c", pythonConfigScript).Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query %s: %w", python, err)
+	}
+	var config PythonConfig
+	err = json.Unmarshal(out, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+var nonAlphanumRegexp = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+// WheelEscape escapes the components of the wheel filename, see
+// https://packaging.python.org/en/latest/specifications/binary-distribution-format/#escaping-and-unicode
+func WheelEscape(v string) string {
+	return nonAlphanumRegexp.ReplaceAllString(v, "_")
+}
+
+type wheelWriter struct {
+	zw     *zip.Writer
+	record []string
+}
+
+func (w *wheelWriter) Add(name string, content []byte) error {
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	header.SetMode(0644)
+	f, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(content)
+	w.record = append(w.record, fmt.Sprintf("%s,sha256=%s,%d", name, base64.RawURLEncoding.EncodeToString(digest[:]), len(content)))
+	return nil
+}
+
+func (w *wheelWriter) AddFile(name string, fname string) error {
+	content, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	return w.Add(name, content)
+}
+
+func (w *wheelWriter) Close(recordName string) error {
+	w.record = append(w.record, recordName+",,")
+	f, err := w.zw.Create(recordName)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte(strings.Join(w.record, "\n") + "\n"))
+	if err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func (x *WheelCommand) Execute(rargs []string) error {
+	args.Process()
+	_, err := x.BuildWheel(args, rargs)
+	return err
+}
+
+// BuildWheel builds the wheel of the python module and returns its filename.
+func (x *WheelCommand) BuildWheel(args Args, fnames []string) (string, error) {
+	config, err := GetPythonConfig(x.Python)
+	if err != nil {
+		return "", err
+	}
+	if slices.Compare(config.Version, []int{3, 10}) < 0 {
+		return "", fmt.Errorf("Python %v is not supported, the generated modules require Python %s", config.Version, requiresPython)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "goserpent-wheel")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if args.OutputPyiStub != "" {
+		args.OutputPyiStub = path.Join(tmpDir, "__init__.pyi")
+	}
+
+	ctx, err := DoPyExports(args, fnames)
+	if err != nil {
+		return "", err
+	}
+
+	soFilename := path.Join(tmpDir, args.PyModuleName+config.ExtSuffix)
+	err = x.BuildLibrary(args, ctx, soFilename)
+	if err != nil {
+		return "", err
+	}
+
+	distName := x.DistName
+	if distName == "" {
+		distName = args.PyModuleName
+	}
+	distInfo := fmt.Sprintf("%s-%s.dist-info", WheelEscape(distName), WheelEscape(x.Version))
+
+	metadata := []string{
+		"Metadata-Version: 2.1",
+		"Name: " + distName,
+		"Version: " + x.Version,
+		"Requires-Python: " + requiresPython,
+	}
+	if x.Summary != "" {
+		metadata = append(metadata, "Summary: "+x.Summary)
+	}
+	requires := x.Requires
+	if ctx.WithNumpy {
+		requires = append(requires, "numpy")
+	}
+	for _, req := range requires {
+		metadata = append(metadata, "Requires-Dist: "+req)
+	}
+
+	wheel := []string{
+		"Wheel-Version: 1.0",
+		"Generator: goserpent",
+		"Root-Is-Purelib: false",
+		"Tag: " + config.Tag,
+	}
+
+	wheelFilename := path.Join(x.WheelDir, fmt.Sprintf("%s-%s-%s.whl", WheelEscape(distName), WheelEscape(x.Version), config.Tag))
+	f, err := os.Create(wheelFilename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The module is packaged as a package whose __init__ is the extension
+	// module, so that the stub file and py.typed marker can be shipped with it
+	w := &wheelWriter{zw: zip.NewWriter(f)}
+	err = w.AddFile(fmt.Sprintf("%s/__init__%s", args.PyModuleName, config.ExtSuffix), soFilename)
+	if err == nil && args.OutputPyiStub != "" {
+		err = w.AddFile(args.PyModuleName+"/__init__.pyi", args.OutputPyiStub)
+		if err == nil {
+			err = w.Add(args.PyModuleName+"/py.typed", nil)
+		}
+	}
+	if err == nil {
+		err = w.Add(distInfo+"/METADATA", []byte(strings.Join(metadata, "\n")+"\n"))
+	}
+	if err == nil {
+		err = w.Add(distInfo+"/WHEEL", []byte(strings.Join(wheel, "\n")+"\n"))
+	}
+	if err == nil {
+		err = w.Close(distInfo + "/RECORD")
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(wheelFilename)
+		return "", err
+	}
+
+	log.Info().Msgf("Built %s", wheelFilename)
+	return wheelFilename, nil
+}
+
+func init() {
+	flagparser.AddCommand("wheel",
+		"Build a wheel",
+		"The wheel command builds an installable wheel of the python library",
+		&wheelCommand)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestWheelCmd(t *testing.T) {
+	args := Args{
+		OutputDir:      "",
+		OutputCCode:    "pyexports.c",
+		OutputChdrCode: "pyexports.h",
+		OutputGoCode:   "pyexports.go",
+		OutputPyiStub:  "testmodule.pyi",
+		PyModuleName:   "testmodule",
+		GoTags:         []string{"python"},
+	}
+
+	wheelDir := t.TempDir()
+	cmd := WheelCommand{
+		Version:  "1.2.3",
+		WheelDir: wheelDir,
+		Python:   "python3",
+	}
+	wheelFilename, err := cmd.BuildWheel(args, []string{"testfile.go"})
+	if err != nil {
+		t.Fatalf("Failed to build wheel: %v", err)
+	}
+
+	installDir := t.TempDir()
+	cmdout, err := exec.Command("python3", "-m", "pip", "install", "--no-index", "--no-deps",
+		"--target", installDir, wheelFilename).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to install wheel: %v. Output: %s", err, cmdout)
+	}
+
+	for _, fname := range []string{"testmodule/__init__.pyi", "testmodule/py.typed", "testmodule-1.2.3.dist-info/RECORD"} {
+		if _, err := os.Stat(installDir + "/" + fname); err != nil {
+			t.Errorf("Missing installed file: %v", err)
+		}
+	}
+
+	pycmd := exec.Command("python3", "-c", "import testmodule; assert testmodule.FunctionReturnInt(21) == 42")
+	pycmd.Dir = installDir
+	cmdout, err = pycmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to import wheel: %v. Output: %s", err, cmdout)
+	}
+}
+
+func TestPEP517Backend(t *testing.T) {
+	binDir := t.TempDir()
+	goserpent := path.Join(binDir, "goserpent")
+	cmdout, err := exec.Command("go", "build", "-o", goserpent).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Compilation error: %v. Output: %s", err, cmdout)
+	}
+	backendPath, err := filepath.Abs("python")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projectDir := t.TempDir()
+	for fname, content := range map[string]string{
+		"go.mod": "module example.com/example\n\ngo 1.23\n",
+		"example.go": `package main
+
+// go:pyexport
+func Square(v int) int {
+	return v * v
+}
+
+func main() {}
+`,
+		"pyproject.toml": `[build-system]
+requires = []
+build-backend = "goserpent_backend"
+
+[project]
+name = "example-module"
+version = "0.2.0"
+
+[tool.goserpent]
+module = "example"
+snake-case = true
+`,
+	} {
+		err := os.WriteFile(path.Join(projectDir, fname), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := append(os.Environ(), "PYTHONPATH="+backendPath, "GOSERPENT="+goserpent)
+	wheelDir := t.TempDir()
+	cmd := exec.Command("python3", "-m", "pip", "wheel", "--no-build-isolation", "--no-deps", "--no-index",
+		"--wheel-dir", wheelDir, ".")
+	cmd.Dir = projectDir
+	cmd.Env = env
+	cmdout, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to build wheel: %v. Output: %s", err, cmdout)
+	}
+
+	wheels, _ := filepath.Glob(path.Join(wheelDir, "example_module-0.2.0-*.whl"))
+	if len(wheels) != 1 {
+		t.Fatalf("Wheel not found in %s", wheelDir)
+	}
+
+	installDir := t.TempDir()
+	cmdout, err = exec.Command("python3", "-m", "pip", "install", "--no-index", "--no-deps",
+		"--target", installDir, wheels[0]).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to install wheel: %v. Output: %s", err, cmdout)
+	}
+
+	pycmd := exec.Command("python3", "-c", "import example; assert example.square(7) == 49")
+	pycmd.Dir = installDir
+	cmdout, err = pycmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to import wheel: %v. Output: %s", err, cmdout)
+	}
+
+	sdistDir := t.TempDir()
+	cmd = exec.Command("python3", "-c", "import sys, goserpent_backend; print(goserpent_backend.build_sdist(sys.argv[1]))", sdistDir)
+	cmd.Dir = projectDir
+	cmd.Env = env
+	cmdout, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to build sdist: %v. Output: %s", err, cmdout)
+	}
+	if _, err := os.Stat(path.Join(sdistDir, "example_module-0.2.0.tar.gz")); err != nil {
+		t.Errorf("Missing sdist: %v", err)
+	}
+}